@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 解析以逗号分隔的质量列表
+func parseQualities(s string) ([]int, error) {
+	var qualities []int
+	for _, part := range strings.Split(s, ",") {
+		q, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", part)
+		}
+		if q < 1 || q > 100 {
+			return nil, errors.New("quality has to be between 1 and 100")
+		}
+		qualities = append(qualities, q)
+	}
+	return qualities, nil
+}
+
+// 返回在扩展名前加上质量后缀的输出路径，如 out.jpg -> out.q80.jpg
+func qualityPath(dest string, quality int) string {
+	ext := filepath.Ext(dest)
+	return fmt.Sprintf("%s.q%d%s", strings.TrimSuffix(dest, ext), quality, ext)
+}
+
+// -ab 的一个输出版本
+type variant struct {
+	quality int
+	data    []byte
+	index   float64
+	err     error
+}
+
+// 并行地按各个质量编码并计算SSIM，结果按qualities的顺序返回
+func encodeVariants(original, reference image.Image, qualities []int) []variant {
+	variants := make([]variant, len(qualities))
+	var wg sync.WaitGroup
+	for i, q := range qualities {
+		wg.Add(1)
+		go func(v *variant, q int) {
+			defer wg.Done()
+			v.quality = q
+			if v.data, v.err = encodeToJPEGBytes(original, q); v.err != nil {
+				return
+			}
+			v.index, v.err = measure(reference, v.data)
+		}(&variants[i], q)
+	}
+	wg.Wait()
+	return variants
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 从 -explain 的说明中取出实际编码的次数
+func explainedLoops(t *testing.T, stdout string) string {
+	t.Helper()
+	m := regexp.MustCompile(`in (\d+) loops`).FindStringSubmatch(stdout)
+	if m == nil {
+		t.Fatalf("no -explain paragraph in:\n%s", stdout)
+	}
+	return m[1]
+}
+
+func TestAbortOnLargerIsOptIn(t *testing.T) {
+	src := writeTaggedJPEG(t, t.TempDir(), 100)
+	dir := t.TempDir()
+
+	stdout, stderr, code := runMain(t, "-explain", "-t", "0.99", src, filepath.Join(dir, "default.jpg"))
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if strings.Contains(stdout, "[+]") {
+		t.Errorf("default run encoded the probe qualities:\n%s", stdout)
+	}
+	if loops, attempts := explainedLoops(t, stdout), regexp.MustCompile(`(?m)^\[\d+\]`).FindAllString(stdout, -1); loops != strconv.Itoa(len(attempts)) {
+		t.Errorf("%v encodes reported for %d search attempts:\n%s", loops, len(attempts), stdout)
+	}
+
+	// 搜索的第一个质量就是探测过的最低质量，直接沿用探测的结果
+	stdout, stderr, code = runMain(t, "-explain", "-abort-on-larger", "-t", "0.99", "-min", "40", "-max", "41", src, filepath.Join(dir, "probe.jpg"))
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "[+] Quality = 40") || !strings.Contains(stdout, "[1] Quality = 40") {
+		t.Fatalf("unexpected search:\n%s", stdout)
+	}
+	if loops := explainedLoops(t, stdout); loops != "1" {
+		t.Errorf("q40 was encoded %v times, want 1:\n%s", loops, stdout)
+	}
+
+	stdout, stderr, code = runMain(t, "-abort-on-larger", "-t", "0.99", writeTinyPNG(t, dir), filepath.Join(dir, "tiny.jpg"))
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "skipping the search") {
+		t.Errorf("-abort-on-larger did not skip the search for an image that only grows:\n%s", stdout)
+	}
+}
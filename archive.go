@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 输出归档，设置后所有输出都写入归档而不是单独的文件
+var outputArchive *archiveWriter
+
+// zip或tar格式的输出归档
+type archiveWriter struct {
+	file *os.File
+	zip  *zip.Writer
+	tar  *tar.Writer
+}
+
+// 根据扩展名创建zip或tar归档
+func createArchive(path string) (*archiveWriter, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".zip" && ext != ".tar" {
+		return nil, errors.New("archive has to be a .zip or .tar file")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveWriter{file: f}
+	if ext == ".zip" {
+		a.zip = zip.NewWriter(f)
+	} else {
+		a.tar = tar.NewWriter(f)
+	}
+	return a, nil
+}
+
+// 将数据作为一个条目写入归档，条目名保留输出的相对路径
+func (a *archiveWriter) add(name string, data []byte) error {
+	name, err := cleanRelative(name)
+	if err != nil {
+		return err
+	}
+	if a.zip != nil {
+		w, err := a.zip.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: entryTime(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	err = a.tar.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: entryTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.tar.Write(data)
+	return err
+}
+
+// 条目的修改时间：-preserve-times时沿用源文件的时间，否则固定为Unix纪元，
+// 使相同的输入和参数得到逐字节相同的归档
+func entryTime() time.Time {
+	if timesFrom != nil {
+		return timesFrom.ModTime()
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// 写入归档的目录并关闭文件
+func (a *archiveWriter) close() error {
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	} else {
+		err = a.tar.Close()
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
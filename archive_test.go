@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 检查归档不存在或者是完整可读的zip/tar文件
+func checkArchiveValid(t *testing.T, name, p string) {
+	t.Helper()
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return
+	}
+	if filepath.Ext(p) == ".zip" {
+		r, err := zip.OpenReader(p)
+		if err != nil {
+			t.Errorf("%s: invalid zip: %v", name, err)
+			return
+		}
+		r.Close()
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("%s: invalid tar: %v", name, err)
+			return
+		}
+	}
+}
+
+func TestArchiveFinalizedOnFailure(t *testing.T) {
+	bad := writeSource(t, "bad.jpg", []byte("not an image"))
+	good := filepath.Join("testdata", "photo.png")
+	tests := []struct {
+		name, src string
+		args      []string
+	}{
+		{"undecodable", bad, nil},
+		{"crop-border", good, []string{"-crop-border", "100"}},
+	}
+	for _, tt := range tests {
+		for _, ext := range []string{".zip", ".tar"} {
+			archive := filepath.Join(t.TempDir(), "out"+ext)
+			args := append(append([]string{"-archive", archive}, tt.args...), tt.src, "out.jpg")
+			if _, _, code := runMain(t, args...); code != 1 {
+				t.Errorf("%s%s: exit %d, want 1", tt.name, ext, code)
+			}
+			checkArchiveValid(t, tt.name+ext, archive)
+		}
+	}
+}
+
+func TestOutputIsReproducible(t *testing.T) {
+	src := filepath.Join("testdata", "photo.png")
+	for _, ext := range []string{".jpg", ".zip", ".tar"} {
+		var outputs [][]byte
+		for i := 0; i < 2; i++ {
+			dir := t.TempDir()
+			args := []string{src, filepath.Join(dir, "out.jpg")}
+			out := args[1]
+			if ext != ".jpg" {
+				out = filepath.Join(dir, "out"+ext)
+				args = []string{"-archive", out, src, "out.jpg"}
+			}
+			if _, stderr, code := runMain(t, args...); code != 0 {
+				t.Fatalf("%s: exit %d: %s", ext, code, stderr)
+			}
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, data)
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("%s: two runs with the same input wrote different bytes", ext)
+		}
+	}
+}
+
+func TestTarEntryTime(t *testing.T) {
+	dir := t.TempDir()
+	png, err := os.ReadFile(filepath.Join("testdata", "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeSource(t, "photo.png", png)
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		args []string
+		want time.Time
+	}{
+		{"default", nil, time.Unix(0, 0)},
+		{"preserve-times", []string{"-preserve-times"}, mtime},
+	} {
+		archive := filepath.Join(dir, tt.name+".tar")
+		args := append(append([]string{"-archive", archive}, tt.args...), src, "out.jpg")
+		if _, stderr, code := runMain(t, args...); code != 0 {
+			t.Fatalf("%s: exit %d: %s", tt.name, code, stderr)
+		}
+		f, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := tar.NewReader(f).Next()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(tt.want) {
+			t.Errorf("%s: entry time %v, want %v", tt.name, hdr.ModTime, tt.want)
+		}
+	}
+}
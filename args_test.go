@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgsOrderings(t *testing.T) {
+	defer func(saved *flag.FlagSet) { flag.CommandLine = saved }(flag.CommandLine)
+
+	tests := []struct {
+		args       []string
+		positional []string
+		force      bool
+		target     float64
+	}{
+		{[]string{"-f", "a.jpg", "b.jpg"}, []string{"a.jpg", "b.jpg"}, true, 0},
+		{[]string{"a.jpg", "b.jpg", "-f"}, []string{"a.jpg", "b.jpg"}, true, 0},
+		{[]string{"a.jpg", "-f", "b.jpg"}, []string{"a.jpg", "b.jpg"}, true, 0},
+		{[]string{"--f", "a.jpg", "b.jpg"}, []string{"a.jpg", "b.jpg"}, true, 0},
+		{[]string{"a.jpg", "-t", "0.9", "b.jpg", "-f=false"}, []string{"a.jpg", "b.jpg"}, false, 0.9},
+		{[]string{"-t=0.95", "a.jpg", "b.jpg", "-f"}, []string{"a.jpg", "b.jpg"}, true, 0.95},
+		// -- 之后的参数都是位置参数，即使以-开头
+		{[]string{"--", "-a.jpg", "b.jpg"}, []string{"-a.jpg", "b.jpg"}, false, 0},
+		{[]string{"-f", "a.jpg", "--", "-b.jpg"}, []string{"a.jpg", "-b.jpg"}, true, 0},
+		{[]string{"a.jpg", "b.jpg", "--", "-f"}, []string{"a.jpg", "b.jpg", "-f"}, false, 0},
+		{nil, nil, false, 0},
+	}
+	for _, tt := range tests {
+		flag.CommandLine = flag.NewFlagSet("jpeg-recompress", flag.ContinueOnError)
+		var force bool
+		var target float64
+		flag.BoolVar(&force, "f", false, "")
+		flag.Float64Var(&target, "t", 0, "")
+
+		positional := parseArgs(tt.args)
+		if !reflect.DeepEqual(positional, tt.positional) || force != tt.force || target != tt.target {
+			t.Errorf("parseArgs(%q) = %q, f=%v, t=%v; want %q, f=%v, t=%v",
+				tt.args, positional, force, target, tt.positional, tt.force, tt.target)
+		}
+	}
+}
+
+func TestForceAfterPositionals(t *testing.T) {
+	src := filepath.Join("testdata", "photo.png")
+	dest := filepath.Join(t.TempDir(), "out.jpg")
+	if err := os.WriteFile(dest, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := runMain(t, src, dest); code != 1 {
+		t.Fatalf("exit %d without -f, want 1", code)
+	}
+	if _, stderr, code := runMain(t, src, dest, "-f"); code != 0 {
+		t.Fatalf("exit %d with -f after the positionals: %s", code, stderr)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) == "existing" {
+		t.Errorf("destination was not overwritten (err %v)", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"text/tabwriter"
+
+	"jpeg-recompress/jpegenc"
+)
+
+// 一个可用的JPEG编码后端
+type backend struct {
+	name   string
+	encode func(img image.Image, quality int) ([]byte, error)
+}
+
+// 以jpegenc编码，沿用 -qtables 等自定义选项，只替换质量和是否优化哈夫曼表
+func jpegencBackend(optimize bool) func(image.Image, int) ([]byte, error) {
+	return func(img image.Image, quality int) ([]byte, error) {
+		var options jpegenc.Options
+		if customEncoder != nil {
+			options = *customEncoder
+		}
+		options.Quality = quality
+		options.OptimizeHuffman = optimize
+		buf := new(bytes.Buffer)
+		if err := jpegenc.Encode(buf, img, &options); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// 所有可用的编码后端
+var backends = []backend{
+	{"image/jpeg", func(img image.Image, quality int) ([]byte, error) {
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}},
+	{"jpegenc", jpegencBackend(false)},
+	{"jpegenc -optimize", jpegencBackend(true)},
+}
+
+// 用每个后端以相同质量编码，打印大小和SSIM的对比表
+func compareBackends(w io.Writer, original, reference image.Image, quality int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Backend\tSize\tSSIM")
+	for _, b := range backends {
+		data, err := b.encode(original, quality)
+		if err != nil {
+			return fmt.Errorf("%v: %w", b.name, err)
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			return fmt.Errorf("%v: %w", b.name, err)
+		}
+		fmt.Fprintf(tw, "%v\t%.2fKB\t%v\n", b.name, float32(len(data))/1024, formatSSIM(index))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// 返回放在更大画布中间的子图像，以及内容相同、原点为(0,0)的副本
+func offsetPair(w, h int, pix func(x, y int) uint8) (image.Image, *image.Gray) {
+	canvas := image.NewGray(image.Rect(-3, -2, w+20, h+20))
+	for i := range canvas.Pix {
+		canvas.Pix[i] = 255
+	}
+	at := image.Rect(11, 7, 11+w, 7+h)
+	flat := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			canvas.SetGray(at.Min.X+x, at.Min.Y+y, color.Gray{Y: pix(x, y)})
+			flat.SetGray(x, y, color.Gray{Y: pix(x, y)})
+		}
+	}
+	return canvas.SubImage(at), flat
+}
+
+func TestOffsetBounds(t *testing.T) {
+	defer func(w, r int) { windowSize, tileRows = w, r }(windowSize, tileRows)
+
+	const w, h = 37, 23
+	sub, flat := offsetPair(w, h, func(x, y int) uint8 {
+		if x < 9 {
+			return 3
+		}
+		return uint8(40 + (x*7+y*13)%160)
+	})
+	noisy := image.NewGray(flat.Bounds())
+	for i, v := range flat.Pix {
+		noisy.Pix[i] = v ^ uint8(i%5)
+	}
+
+	modes := []struct {
+		name           string
+		window, strips int
+	}{
+		{"global", 0, 0},
+		{"window", 8, 0},
+		{"tiles", 0, 5},
+	}
+	for _, m := range modes {
+		windowSize, tileRows = m.window, m.strips
+		if got := ssim(sub, flat); got != 1 {
+			t.Errorf("%s: ssim(offset, same pixels) = %v, want 1", m.name, got)
+		}
+		got, want := ssim(sub, noisy), ssim(flat, noisy)
+		if math.Abs(got-want) > 1e-12 {
+			t.Errorf("%s: ssim with offset bounds = %v, want %v", m.name, got, want)
+		}
+	}
+
+	if got := psnr(sub, flat); !math.IsInf(got, 1) {
+		t.Errorf("psnr(offset, same pixels) = %v, want +Inf", got)
+	}
+	if got, want := psnr(sub, noisy), psnr(flat, noisy); got != want {
+		t.Errorf("psnr with offset bounds = %v, want %v", got, want)
+	}
+
+	shadows, highlights := extremeFractions(sub)
+	if want := float64(9*h) / float64(w*h); shadows != want || highlights != 0 {
+		t.Errorf("extremeFractions = %v, %v, want %v, 0", shadows, highlights, want)
+	}
+}
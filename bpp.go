@@ -0,0 +1,21 @@
+package main
+
+import "image"
+
+// 在[minQ, maxQ]中二分查找输出不超过budget字节的最高质量
+func highestWithin(img image.Image, budget int64, minQ, maxQ int) (bestQ int, ok bool) {
+	for minQ <= maxQ {
+		q := minQ + (maxQ-minQ)/2
+		data, err := encodeToJPEGBytes(img, q)
+		if err != nil {
+			return
+		}
+		if int64(len(data)) <= budget {
+			bestQ, ok = q, true
+			minQ = q + 1
+		} else {
+			maxQ = q - 1
+		}
+	}
+	return
+}
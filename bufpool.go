@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// 编码用的缓冲区池。搜索中同一图片要反复编码，复用缓冲区可以避免每次从零容量开始扩容
+var encodeBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// 从池中取出一个清空的缓冲区
+func getBuffer() *bytes.Buffer {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// 复制出缓冲区的内容并把缓冲区放回池中，之后不能再使用buf
+func releaseBuffer(buf *bytes.Buffer) []byte {
+	data := bytes.Clone(buf.Bytes())
+	encodeBuffers.Put(buf)
+	return data
+}
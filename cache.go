@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// 不影响输出内容、不计入缓存键的参数
+var cacheIgnoredFlags = map[string]bool{
+	"cache": true, "f": true, "v": true, "q": true, "explain": true, "sidecar": true,
+	"cpuprofile": true, "memprofile": true, "bpp": true, "ssim-display": true, "mkdir": true,
+	"preserve-times": true, "chmod": true, "archive": true,
+}
+
+// 缓存中记录的决定
+type cacheEntry struct {
+	Quality int     `json:"quality"`
+	SSIM    float64 `json:"ssim"`
+	// 为true时没有输出数据，命中时复制原图
+	Copied bool `json:"copied"`
+}
+
+// 由源文件内容、影响输出的参数以及inputs中各文件的内容计算缓存键，
+// 不存在的文件与空路径一样只记录其缺失
+func cacheKey(src string, inputs ...string) (string, error) {
+	data, err := readSource(src)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	for _, p := range inputs {
+		extra, err := os.ReadFile(p)
+		if p == "" || os.IsNotExist(err) {
+			h.Write([]byte("\x00-"))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte("\x00" + strconv.Itoa(len(extra)) + ":"))
+		h.Write(extra)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !cacheIgnoredFlags[f.Name] {
+			h.Write([]byte("\x00" + f.Name + "=" + f.Value.String()))
+		}
+	})
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 读取缓存的决定及输出数据
+func loadCache(dir, key string) (entry cacheEntry, data []byte, ok bool) {
+	raw, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil || json.Unmarshal(raw, &entry) != nil {
+		return entry, nil, false
+	}
+	if entry.Copied {
+		return entry, nil, true
+	}
+	data, err = os.ReadFile(filepath.Join(dir, key+".jpg"))
+	return entry, data, err == nil
+}
+
+// 将决定及输出数据写入缓存，先写数据再写记录，避免留下没有数据的记录
+func storeCache(dir, key string, entry cacheEntry, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if !entry.Copied {
+		if err := os.WriteFile(filepath.Join(dir, key+".jpg"), data, 0644); err != nil {
+			return err
+		}
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, key+".json"), raw, 0644)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCacheKeyHashesSideInputs(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.jpg")
+	meta := filepath.Join(dir, "meta.jpg")
+	tables := filepath.Join(dir, "tables.json")
+	write := func(p, s string) {
+		t.Helper()
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := func() string {
+		t.Helper()
+		k, err := cacheKey(src, targetSidecarPath(src), meta, tables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	write(src, "jpeg bytes")
+	seen := map[string]string{key(): "no side files"}
+	steps := []struct {
+		name, path, content string
+	}{
+		{"target 0.99", src + ".target", "0.99"},
+		{"target 0.95", src + ".target", "0.95"},
+		{"metadata A", meta, "exif A"},
+		{"metadata B", meta, "exif B"},
+		{"qtables A", tables, `{"luma": [16]}`},
+		{"qtables B", tables, `{"luma": [17]}`},
+	}
+	for _, s := range steps {
+		write(s.path, s.content)
+		k := key()
+		if prev, ok := seen[k]; ok {
+			t.Errorf("%s: same cache key as %s", s.name, prev)
+		}
+		seen[k] = s.name
+	}
+
+	// 内容相同时键不变
+	write(meta, "exif A")
+	first := key()
+	write(meta, "exif A")
+	if key() != first {
+		t.Error("cache key changed although no input changed")
+	}
+}
+
+// 写入所有值都为v的8x8亮度和色度量化表
+func writeFlatTables(t *testing.T, path string, v int) {
+	t.Helper()
+	row := strings.TrimSuffix(strings.Repeat(strconv.Itoa(v)+",", 8), ",")
+	table := "[" + strings.TrimSuffix(strings.Repeat("["+row+"],", 8), ",") + "]"
+	data := `{"luma": ` + table + `, "chroma": ` + table + `}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheMissWhenQTablesChange(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join("testdata", "photo.png")
+	tables := filepath.Join(dir, "tables.json")
+	cache := filepath.Join(dir, "cache")
+	dest := filepath.Join(dir, "out.jpg")
+	run := func() string {
+		t.Helper()
+		stdout, stderr, code := runMain(t, "-f", "-cache", cache, "-qtables", tables, src, dest)
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, stderr)
+		}
+		return stdout
+	}
+
+	writeFlatTables(t, tables, 2)
+	run()
+	if out := run(); !strings.Contains(out, "Cache hit") {
+		t.Fatalf("same tables: expected a cache hit, got:\n%s", out)
+	}
+	writeFlatTables(t, tables, 40)
+	if out := run(); strings.Contains(out, "Cache hit") {
+		t.Errorf("edited tables: expected a cache miss, got:\n%s", out)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 解析 -calibrate 的参数，接受 q=80 或 80
+func parseCalibration(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "q=")
+	q, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("'" + s + "' is not a quality")
+	}
+	if q < 1 || q > 100 {
+		return 0, errors.New("quality has to be between 1 and 100")
+	}
+	return q, nil
+}
+
+// 将SSIM向下截取到5位小数，使校准时的质量本身能满足建议的目标
+func suggestedTarget(index float64) float64 {
+	return math.Floor(index*1e5) / 1e5
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strconv"
+	"strings"
+)
+
+// 各通道(Y, Cb, Cr，或lab空间下的L*, a*, b*)SSIM的归一化权重，
+// 为nil时只计算灰阶（或L*）SSIM
+var channelWeights *[3]float64
+
+// 解析以逗号分隔的三个通道权重并归一化
+func parseChannelWeights(s string) (*[3]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return nil, errors.New("expected three weights")
+	}
+	var weights [3]float64
+	sum := 0.0
+	for i, part := range parts {
+		w, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", part)
+		}
+		if w < 0 {
+			return nil, errors.New("weights can't be negative")
+		}
+		weights[i] = w
+		sum += w
+	}
+	if sum == 0 {
+		return nil, errors.New("at least one weight has to be more than 0")
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return &weights, nil
+}
+
+// 将图像拆分为Y、Cb、Cr三个通道
+func splitYCbCr(img image.Image) [3]*image.Gray {
+	bounds := img.Bounds()
+	var planes [3]*image.Gray
+	for i := range planes {
+		planes[i] = image.NewGray(bounds)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr)
+			planes[0].SetGray(x, y, color.Gray{Y: c.Y})
+			planes[1].SetGray(x, y, color.Gray{Y: c.Cb})
+			planes[2].SetGray(x, y, color.Gray{Y: c.Cr})
+		}
+	}
+	return planes
+}
+
+// 是否需要在彩色图像上按通道计算SSIM
+func perChannel() bool {
+	return channelWeights != nil || ssimSpace == "lab"
+}
+
+// 按ssimSpace将图像拆分为三个通道
+func splitChannels(img image.Image) [3]*image.Gray {
+	if ssimSpace == "lab" {
+		return splitLab(img)
+	}
+	return splitYCbCr(img)
+}
+
+// 计算两个图像按通道加权的SSIM
+func weightedSSIM(x, y image.Image, weights *[3]float64) float64 {
+	planesX := splitChannels(x)
+	planesY := splitChannels(y)
+
+	index := 0.0
+	for i, w := range weights {
+		if w == 0 {
+			continue
+		}
+		index += w * ssim(planesX[i], planesY[i])
+	}
+	return index
+}
+
+// 计算输出与原图在三个通道上各自的SSIM及其平均值
+func colorSSIM(original image.Image, raw []byte) (mean float64, channels [3]float64, err error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	if borderCrop > 0 {
+		original = cropBorder(original, borderCrop)
+		decoded = cropBorder(decoded, borderCrop)
+	}
+	planesX := splitChannels(original)
+	planesY := splitChannels(decoded)
+	for i := range channels {
+		channels[i] = ssim(planesX[i], planesY[i])
+		mean += channels[i] / 3
+	}
+	return
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"jpeg-recompress/jpegenc"
+)
+
+// 以不同的亮度和色度质量编码
+func encodeWithChroma(img image.Image, lumaQ, chromaQ int) ([]byte, error) {
+	var options jpegenc.Options
+	if customEncoder != nil {
+		options = *customEncoder
+	}
+	options.Quality = lumaQ
+	options.ChromaQuality = chromaQ
+
+	buf := getBuffer()
+	err := jpegenc.Encode(buf, img, &options)
+	data := releaseBuffer(buf)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 固定亮度质量，二分搜索色度SSIM不低于chromaTarget的最低色度质量。
+// 色度量化经过颜色转换仍会略微改变亮度SSIM，调用者需要重新检查是否满足目标
+func searchChroma(original image.Image, lumaQ, minQ int, chromaTarget float64, loops int) int {
+	chromaWeights := &[3]float64{0, 0.5, 0.5}
+	best := lumaQ
+	low, high := minQ, lumaQ-1
+	for attempt := 1; attempt <= loops && low <= high; attempt++ {
+		q := low + (high-low)/2
+		data, err := encodeWithChroma(original, lumaQ, q)
+		if err != nil {
+			panic(err)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+		index := weightedSSIM(original, decoded, chromaWeights)
+		fmt.Printf("[c%v] Chroma Quality = %v, Chroma SSIM = %v, Size = %.2fKB%v\n", attempt, q, formatSSIM(index), float32(len(data))/1024, bppSuffix(int64(len(data))))
+
+		if index >= chromaTarget {
+			best = q
+			high = q - 1
+		} else {
+			low = q + 1
+		}
+	}
+	return best
+}
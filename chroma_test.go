@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 写出一张有彩色渐变和边缘的JPEG，返回文件路径
+func writeColorJPEG(t *testing.T, dir string) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			c := color.RGBA{uint8(x * 255 / 95), uint8(y * 255 / 63), uint8((x + y) % 64 * 4), 255}
+			if (x/12+y/12)%2 == 0 {
+				c.B = 255 - c.B
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	p := filepath.Join(dir, "color.jpg")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 97}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSeparateChromaReportsWrittenSize(t *testing.T) {
+	dir := t.TempDir()
+	src := writeColorJPEG(t, dir)
+	dest := filepath.Join(dir, "out.jpg")
+	stdout, stderr, code := runMain(t, "-t", "0.999", "-separate-chroma", "-chroma-target", "0.97", src, dest)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Chroma Quality") {
+		t.Fatalf("no chroma search ran:\n%s", stdout)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Size = %.2fKB", float32(info.Size())/1024)
+	_, final, _ := strings.Cut(stdout, "Final image:")
+	if !strings.Contains(final, want) {
+		t.Errorf("final report does not contain %q for the %d-byte output:\n%s", want, info.Size(), final)
+	}
+}
+
+func TestSeparateChromaKeepsTarget(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out.jpg")
+	// 三个通道等权时最低的色度质量明显降低测得的SSIM，不再满足目标
+	stdout, stderr, code := runMain(t, "-t", "0.98", "-separate-chroma", "-chroma-target", "0.5", "-channel-weights", "1,1,1", filepath.Join("testdata", "photo.png"), dest)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "misses the target") {
+		t.Fatalf("the lowered chroma quality was not rejected:\n%s", stdout)
+	}
+	_, final, _ := strings.Cut(stdout, "Final image:")
+	var q int
+	var index float64
+	if _, err := fmt.Sscanf(strings.TrimSpace(final), "Quality = %d, SSIM = %f,", &q, &index); err != nil {
+		t.Fatalf("no final report: %v\n%s", err, stdout)
+	}
+	if index < 0.98 {
+		t.Errorf("final SSIM %v is below the 0.98 target:\n%s", index, stdout)
+	}
+}
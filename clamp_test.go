@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClampSSIM(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{1.0000001, 1},
+		{math.Nextafter(1, 2), 1},
+		{1, 1},
+		{0.99995, 0.99995},
+		{0, 0},
+		{-0.2, -0.2},
+	}
+	for _, tt := range tests {
+		if got := clampSSIM(tt.in); got != tt.want {
+			t.Errorf("clampSSIM(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIdenticalPairIsExactlyOne(t *testing.T) {
+	defer func(w, r int) { windowSize, tileRows = w, r }(windowSize, tileRows)
+
+	modes := []struct {
+		name           string
+		window, strips int
+	}{
+		{"global", 0, 0},
+		{"window", 8, 0},
+		{"tiles", 0, 5},
+	}
+	for _, fixture := range []string{"photo", "screenshot", "gradient", "noise"} {
+		img := loadFixture(t, fixture)
+		gray := convertToGray(img)
+		for _, m := range modes {
+			windowSize, tileRows = m.window, m.strips
+			if got := ssim(gray, gray); got != 1 {
+				t.Errorf("%s %s: ssim = %.17g, want exactly 1", fixture, m.name, got)
+			}
+			if got := streamSSIM(gray, img); got != 1 {
+				t.Errorf("%s %s: low-memory ssim = %.17g, want exactly 1", fixture, m.name, got)
+			}
+		}
+	}
+}
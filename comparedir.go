@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// 比较原图目录和输出目录中相对路径相同的文件，打印每对图片的SSIM和大小比例，
+// 返回SSIM低于threshold或无法比较的文件数
+func compareDirs(w io.Writer, origDir, outDir string, threshold float64) (flagged int, err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "File\tSSIM\tSize\t")
+
+	err = filepath.WalkDir(origDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(origDir, p)
+		if err != nil {
+			return err
+		}
+		index, ratio, err := comparePair(p, filepath.Join(outDir, rel))
+		if err != nil {
+			flagged++
+			fmt.Fprintf(tw, "%v\t-\t-\t* %v\n", rel, err)
+			return nil
+		}
+		note := ""
+		if index < threshold {
+			flagged++
+			note = "* below target"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%.1f%%\t%v\n", rel, formatSSIM(index), ratio*100, note)
+		return nil
+	})
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return flagged, err
+}
+
+// 计算一对图片的灰阶SSIM，以及输出文件相对原图的大小比例
+func comparePair(orig, out string) (index, ratio float64, err error) {
+	if _, err = os.Stat(out); err != nil {
+		return 0, 0, fmt.Errorf("no output: %w", err)
+	}
+	img1, err := readImage(orig)
+	if err != nil {
+		return 0, 0, err
+	}
+	img2, err := readImage(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = checkDim(img1, img2); err != nil {
+		return 0, 0, err
+	}
+
+	origSize, err := getFilesize(orig)
+	if err != nil {
+		return 0, 0, err
+	}
+	outSize, err := getFilesize(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ssim(convertToGray(img1), convertToGray(img2)), float64(outSize) / float64(origSize), nil
+}
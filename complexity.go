@@ -0,0 +1,47 @@
+package main
+
+import "image"
+
+// 平均梯度达到该值时视为复杂度为1
+const fullComplexityGradient = 16.0
+
+// 用平均梯度（相邻像素亮度差的绝对值）估算灰阶图像的复杂度，范围0到1
+func complexity(gray image.Image) float64 {
+	b := gray.Bounds()
+	sum, n := 0.0, 0
+	for y := b.Min.Y; y < b.Max.Y-1; y++ {
+		for x := b.Min.X; x < b.Max.X-1; x++ {
+			p := getPixVal(gray.At(x, y))
+			dx := getPixVal(gray.At(x+1, y)) - p
+			dy := getPixVal(gray.At(x, y+1)) - p
+			if dx < 0 {
+				dx = -dx
+			}
+			if dy < 0 {
+				dy = -dy
+			}
+			sum += dx + dy
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	c := sum / float64(n) / 2 / fullComplexityGradient
+	if c > 1 {
+		c = 1
+	}
+	return c
+}
+
+// 按复杂度在 [base-spread, base+spread] 内调整目标SSIM：平坦的图降低目标，细节多的图提高目标
+func adaptiveTarget(base, spread, c float64) float64 {
+	t := base + spread*(2*c-1)
+	if t > 1 {
+		t = 1
+	}
+	if t <= 0 {
+		t = base
+	}
+	return t
+}
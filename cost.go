@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -cost 的预设，值为每单位SSIM损失折合的字节数lambda
+var costPresets = map[string]float64{
+	"small":    1e7,
+	"balanced": 1e8,
+	"quality":  1e9,
+}
+
+var costPattern = regexp.MustCompile(`^size\+([0-9.eE+]+)\*\(1-ssim\)$`)
+
+// 解析 -cost：预设名，或 size+LAMBDA*(1-ssim) 形式的表达式，返回lambda
+func parseCost(s string) (float64, error) {
+	s = strings.ReplaceAll(strings.ToLower(s), " ", "")
+	if lambda, ok := costPresets[s]; ok {
+		return lambda, nil
+	}
+	m := costPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, errors.New("'" + s + "' is not small, balanced, quality or size+LAMBDA*(1-ssim)")
+	}
+	lambda, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || lambda < 0 {
+		return 0, errors.New("'" + m[1] + "' is not a valid lambda")
+	}
+	return lambda, nil
+}
+
+// 一个质量的代价及其编码结果
+type costResult struct {
+	cost  float64
+	index float64
+	data  []byte
+}
+
+// 在[minQ, maxQ]中用三分搜索找代价 size + lambda*(1-SSIM) 最小的质量，
+// 假设代价在质量范围内大致是单峰的
+func minimizeCost(img, reference image.Image, lambda float64, minQ, maxQ int) (bestQ int, best costResult, err error) {
+	results := map[int]costResult{}
+	eval := func(q int) (costResult, error) {
+		if r, ok := results[q]; ok {
+			return r, nil
+		}
+		data, err := encodeToJPEGBytes(img, q)
+		if err != nil {
+			return costResult{}, err
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			return costResult{}, err
+		}
+		r := costResult{float64(len(data)) + lambda*(1-index), index, data}
+		results[q] = r
+		return r, nil
+	}
+
+	lo, hi := minQ, maxQ
+	for hi-lo > 2 {
+		m1 := lo + (hi-lo)/3
+		m2 := hi - (hi-lo)/3
+		r1, err := eval(m1)
+		if err != nil {
+			return 0, costResult{}, err
+		}
+		r2, err := eval(m2)
+		if err != nil {
+			return 0, costResult{}, err
+		}
+		if r1.cost <= r2.cost {
+			hi = m2 - 1
+		} else {
+			lo = m1 + 1
+		}
+	}
+	for q := lo; q <= hi; q++ {
+		r, err := eval(q)
+		if err != nil {
+			return 0, costResult{}, err
+		}
+		if bestQ == 0 || r.cost < best.cost {
+			bestQ, best = q, r
+		}
+	}
+	return bestQ, best, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// 计算SSIM时从图像四周排除的像素数
+var borderCrop int
+
+// 去掉图像四周n个像素，返回原点为(0, 0)的副本
+func cropBorder(img image.Image, n int) image.Image {
+	b := img.Bounds()
+	inner := image.Rect(b.Min.X+n, b.Min.Y+n, b.Max.X-n, b.Max.Y-n)
+	r := image.Rect(0, 0, inner.Dx(), inner.Dy())
+
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(r)
+	} else {
+		dst = image.NewRGBA(r)
+	}
+	draw.Draw(dst, r, img, inner.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// 解析 WxH 形式的尺寸，如 64x64
+func parseDimensions(s string) (w, h int, err error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "x")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("'" + s + "' is not in WxH form")
+	}
+	if w, err = strconv.Atoi(parts[0]); err != nil || w <= 0 {
+		return 0, 0, errors.New("'" + s + "' has an invalid width")
+	}
+	if h, err = strconv.Atoi(parts[1]); err != nil || h <= 0 {
+		return 0, 0, errors.New("'" + s + "' has an invalid height")
+	}
+	return w, h, nil
+}
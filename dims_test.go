@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mismatchMessage = "images are 96x64 and 80x48"
+
+func TestMeasureMismatchedDimensions(t *testing.T) {
+	photo := loadFixture(t, "photo")
+	small := image.NewGray(image.Rect(0, 0, 80, 48))
+	raw, err := encodeToJPEGBytes(small, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := measure(convertToGray(photo), raw); err == nil || !strings.Contains(err.Error(), mismatchMessage) {
+		t.Errorf("measure err = %v, want %q", err, mismatchMessage)
+	}
+}
+
+func TestCompareDirsMismatchedDimensions(t *testing.T) {
+	origDir, outDir := t.TempDir(), t.TempDir()
+	orig, err := encodeToJPEGBytes(loadFixture(t, "photo"), 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeToJPEGBytes(image.NewGray(image.Rect(0, 0, 80, 48)), 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(origDir, "a.jpg"), orig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "a.jpg"), out, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var report bytes.Buffer
+	flagged, err := compareDirs(&report, origDir, outDir, 0.999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flagged != 1 || !strings.Contains(report.String(), mismatchMessage) {
+		t.Errorf("flagged %d, report:\n%s", flagged, report.String())
+	}
+}
+
+func TestSSIMMismatchedDimensionsPanics(t *testing.T) {
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !strings.Contains(err.Error(), mismatchMessage) {
+			t.Errorf("recovered %v, want an error containing %q", r, mismatchMessage)
+		}
+	}()
+	ssim(image.NewGray(image.Rect(0, 0, 96, 64)), image.NewGray(image.Rect(0, 0, 80, 48)))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// 输出中SSIM的显示方式：linear、db 或 percent，只影响显示，不影响目标和计算
+var ssimDisplay = "linear"
+
+// 按 ssimDisplay 格式化SSIM值
+func formatSSIM(index float64) string {
+	switch ssimDisplay {
+	case "db":
+		return fmt.Sprintf("%.2fdB", -10*math.Log10(1-index))
+	case "percent":
+		return fmt.Sprintf("%.3f%%", index*100)
+	}
+	return fmt.Sprintf("%.5f", index)
+}
+
+// 不为0时，在大小之后显示每像素的比特数(BPP)，值为图像的像素数
+var bppPixels int
+
+// 返回附加在大小之后的BPP文字，未启用时为空
+func bppSuffix(size int64) string {
+	if bppPixels == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", BPP = %.3f", float64(size*8)/float64(bppPixels))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// 4x4 Bayer有序抖动矩阵
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// 判断图像是否每通道超过8位
+func highBitDepth(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return true
+	}
+	return false
+}
+
+// 将高位深图像用有序抖动量化到每通道8位，避免平滑渐变出现色带
+func ditherImage(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	quantize := func(v uint32, threshold float64) uint8 {
+		q := int(float64(v)/257 + threshold)
+		if q > 255 {
+			q = 255
+		}
+		return uint8(q)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			t := (bayer4[y&3][x&3] + 0.5) / 16
+			r, g, bl, a := img.At(x, y).RGBA()
+			c := color.RGBA{quantize(r, t), quantize(g, t), quantize(bl, t), quantize(a, t)}
+			// 预乘alpha的颜色值不能超过alpha
+			if c.R > c.A {
+				c.R = c.A
+			}
+			if c.G > c.A {
+				c.G = c.A
+			}
+			if c.B > c.A {
+				c.B = c.A
+			}
+			out.SetRGBA(x, y, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDitherSixteenBitPNG(t *testing.T) {
+	// 一个跨度不到一个8位灰阶的平滑渐变，直接截断只能得到一两个灰阶
+	src := image.NewRGBA64(image.Rect(0, 0, 64, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint16(1000 + x*4)
+			src.SetRGBA64(x, y, color.RGBA64{v, v, v, 0xffff})
+		}
+	}
+	p := filepath.Join(t.TempDir(), "gradient16.png")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	img, err := readImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !highBitDepth(img) {
+		t.Fatalf("decoded %T is not treated as high bit depth", img)
+	}
+
+	out := ditherImage(img)
+	// 抖动后每列在4x4矩阵的一个周期内的平均值应接近原来的16位值
+	for x := 0; x < 64; x++ {
+		sum := 0.0
+		for y := 0; y < 16; y++ {
+			sum += float64(out.RGBAAt(x, y).R)
+		}
+		want := float64(1000+x*4) / 257
+		if got := sum / 16; got < want-0.5 || got > want+0.5 {
+			t.Errorf("column %d averages %.2f, want about %.2f", x, got, want)
+		}
+	}
+
+	if highBitDepth(convertToGray(img)) {
+		t.Error("an 8-bit gray image is treated as high bit depth")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"os"
+)
+
+// 标准亮度量化表（JPEG规范K.1节），zig-zag顺序
+var standardLumaQuant = [64]float64{
+	16, 11, 12, 14, 12, 10, 16, 14,
+	13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37,
+	29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68,
+	87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113,
+	121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// 读取源图片的原始数据
+func readSource(path string) ([]byte, error) {
+	if isURL(path) {
+		return fetchURL(path)
+	}
+	return os.ReadFile(path)
+}
+
+// 根据JPEG的亮度量化表(DQT)估算其编码质量，无法估算时ok为false
+func estimateJPEGQuality(data []byte) (quality int, ok bool) {
+	segments, _ := jpegSegments(data)
+	for _, seg := range segments {
+		if seg.marker != 0xdb {
+			continue
+		}
+		table := seg.data
+		for len(table) > 0 {
+			precision, id := table[0]>>4, table[0]&0x0f
+			size := 64
+			if precision != 0 {
+				size = 128
+			}
+			if len(table) < 1+size {
+				return 0, false
+			}
+			if id == 0 {
+				return qualityFromTable(table[1:1+size], precision != 0), true
+			}
+			table = table[1+size:]
+		}
+	}
+	return 0, false
+}
+
+// 根据量化表相对标准表的缩放比例反推质量
+func qualityFromTable(table []byte, wide bool) int {
+	scale := 0.0
+	for i := 0; i < 64; i++ {
+		v := float64(table[i])
+		if wide {
+			v = float64(int(table[2*i])<<8 | int(table[2*i+1]))
+		}
+		scale += v * 100 / standardLumaQuant[i]
+	}
+	scale /= 64
+
+	var quality float64
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	} else {
+		quality = 5000 / scale
+	}
+	return int(math.Max(1, math.Min(100, math.Round(quality))))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// 计算灰阶图像直方图的香农熵，单位为比特/像素，范围0到8。
+// 接近8说明亮度分布很平，通常是噪点多、重新压缩收益小的内容
+func grayEntropy(gray image.Image) float64 {
+	var histogram [256]int
+	b := gray.Bounds()
+	if g, ok := gray.(*image.Gray); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, v := range g.Pix[g.PixOffset(b.Min.X, y):g.PixOffset(b.Max.X, y)] {
+				histogram[v]++
+			}
+		}
+	} else {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				histogram[int(getPixVal(gray.At(x, y)))]++
+			}
+		}
+	}
+
+	n := float64(b.Dx() * b.Dy())
+	entropy := 0.0
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
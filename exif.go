@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// -filter 支持的EXIF字段及其标签号
+var exifFields = map[string]uint16{
+	"make":             0x010f,
+	"model":            0x0110,
+	"datetime":         0x0132,
+	"datetimeoriginal": 0x9003,
+}
+
+// 读取JPEG的EXIF中 exifFields 列出的ASCII字段，键为小写字段名
+func readExif(data []byte) map[string]string {
+	fields := map[string]string{}
+	segments, _ := jpegSegments(data)
+	for _, seg := range segments {
+		if seg.marker == 0xe1 && bytes.HasPrefix(seg.data, []byte("Exif\x00\x00")) {
+			readTIFF(seg.data[6:], fields)
+			break
+		}
+	}
+	return fields
+}
+
+// 解析TIFF结构中IFD0和Exif子IFD里的ASCII字段
+func readTIFF(tiff []byte, fields map[string]string) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	names := map[uint16]string{}
+	for name, tag := range exifFields {
+		names[tag] = name
+	}
+
+	// 记录已解析的IFD，防止指回IFD0或自身的偏移造成死循环
+	visited := map[uint32]bool{}
+	ifds := []uint32{order.Uint32(tiff[4:8])}
+	for len(ifds) > 0 {
+		off := int(ifds[0])
+		ifds = ifds[1:]
+		if visited[uint32(off)] || off <= 0 || off+2 > len(tiff) {
+			continue
+		}
+		visited[uint32(off)] = true
+		n := int(order.Uint16(tiff[off:]))
+		for i := 0; i < n; i++ {
+			e := off + 2 + i*12
+			if e+12 > len(tiff) {
+				break
+			}
+			tag, typ, count := order.Uint16(tiff[e:]), order.Uint16(tiff[e+2:]), int(order.Uint32(tiff[e+4:]))
+			// Exif子IFD的偏移
+			if tag == 0x8769 {
+				ifds = append(ifds, order.Uint32(tiff[e+8:]))
+				continue
+			}
+			name, ok := names[tag]
+			if !ok || typ != 2 || count <= 0 {
+				continue
+			}
+			value := tiff[e+8 : e+12]
+			if count > 4 {
+				vo := int(order.Uint32(tiff[e+8:]))
+				if vo < 0 || vo+count > len(tiff) {
+					continue
+				}
+				value = tiff[vo : vo+count]
+			} else {
+				value = value[:count]
+			}
+			fields[name] = strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+		}
+	}
+}
+
+// -filter 的比较条件
+type exifPredicate struct {
+	field, op, value string
+}
+
+// 支持的比较运算符，较长的在前以便优先匹配
+var exifOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// 解析 exif.字段 运算符 值 形式的条件，如 exif.DateTime>2020
+func parseExifFilter(s string) (exifPredicate, error) {
+	for _, op := range exifOperators {
+		if i := strings.Index(s, op); i > 0 {
+			field := strings.ToLower(strings.TrimSpace(s[:i]))
+			field = strings.TrimPrefix(field, "exif.")
+			if _, ok := exifFields[field]; !ok {
+				return exifPredicate{}, errors.New("unknown field '" + field + "', use Make, Model, DateTime or DateTimeOriginal")
+			}
+			return exifPredicate{field, op, strings.TrimSpace(s[i+len(op):])}, nil
+		}
+	}
+	return exifPredicate{}, errors.New("'" + s + "' has no operator, use =, !=, >, >=, < or <=")
+}
+
+// 按字符串比较判断EXIF字段是否满足条件，缺少该字段时不满足
+func (p exifPredicate) match(fields map[string]string) bool {
+	v, ok := fields[p.field]
+	if !ok {
+		return false
+	}
+	switch p.op {
+	case "=":
+		return v == p.value
+	case "!=":
+		return v != p.value
+	case ">":
+		return v > p.value
+	case ">=":
+		return v >= p.value
+	case "<":
+		return v < p.value
+	}
+	return v <= p.value
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// 构造只含一个IFD的小端TIFF，其中Make为ASCII字段，0x8769指向exifOffset
+func loopingTIFF(exifOffset uint32) []byte {
+	tiff := make([]byte, 8+2+2*12+4+8)
+	le := binary.LittleEndian
+	copy(tiff, "II")
+	le.PutUint16(tiff[2:], 42)
+	le.PutUint32(tiff[4:], 8)
+	le.PutUint16(tiff[8:], 2)
+
+	e := 10
+	le.PutUint16(tiff[e:], 0x010f)
+	le.PutUint16(tiff[e+2:], 2)
+	le.PutUint32(tiff[e+4:], 6)
+	le.PutUint32(tiff[e+8:], 38)
+	copy(tiff[38:], "Canon\x00")
+
+	e += 12
+	le.PutUint16(tiff[e:], 0x8769)
+	le.PutUint16(tiff[e+2:], 4)
+	le.PutUint32(tiff[e+4:], 1)
+	le.PutUint32(tiff[e+8:], exifOffset)
+	return tiff
+}
+
+func TestReadTIFFSelfReferencingIFD(t *testing.T) {
+	// 8为IFD0自身的偏移，修复前会无限循环直到测试超时
+	for _, off := range []uint32{8, 0} {
+		fields := map[string]string{}
+		readTIFF(loopingTIFF(off), fields)
+		if fields["make"] != "Canon" {
+			t.Errorf("Exif pointer %d: make = %q, want Canon", off, fields["make"])
+		}
+	}
+}
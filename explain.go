@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// -explain 用来描述最终决定的信息
+type explanation struct {
+	isJPEG       bool
+	sourceQ      int
+	originalSize int64
+	minQ, maxQ   int
+	attempts     int
+	target       float64
+	// 结果：optimized、copied、kept、fallback、none 或 interrupted
+	outcome string
+	quality int
+	index   float64
+	// -color-ssim 计算的三个通道SSIM的平均值
+	colorIndex float64
+	size       int64
+	dest       string
+	// -entropy 计算的原图灰阶熵
+	entropy float64
+	// 原图文件的实际大小，originalSize 在 -payload-size 时不含元数据
+	sourceSize int64
+}
+
+// 以KB或MB表示字节数
+func formatSize(n int64) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.1fKB", float64(n)/1024)
+}
+
+// 生成一段描述最终决定的文字
+func (e explanation) String() string {
+	var b strings.Builder
+	if e.isJPEG && e.sourceQ > 0 {
+		fmt.Fprintf(&b, "Source is JPEG at estimated q%v, %v. ", e.sourceQ, formatSize(e.originalSize))
+	} else if e.isJPEG {
+		fmt.Fprintf(&b, "Source is JPEG, %v. ", formatSize(e.originalSize))
+	} else {
+		fmt.Fprintf(&b, "Source is not a JPEG, %v. ", formatSize(e.originalSize))
+	}
+	fmt.Fprintf(&b, "Searched q%v-%v in %v loops. ", e.minQ, e.maxQ, e.attempts)
+
+	saved := func() float64 {
+		return float64(e.originalSize-e.size) / float64(e.originalSize) * 100
+	}
+	switch e.outcome {
+	case "optimized":
+		fmt.Fprintf(&b, "Best meeting SSIM %v was q%v at %v (%.0f%% saved). Wrote %v.",
+			e.target, e.quality, formatSize(e.size), saved(), e.dest)
+	case "fallback":
+		fmt.Fprintf(&b, "Nothing met SSIM %v while being smaller, so the closest match q%v (SSIM %v, %v) was written to %v.",
+			e.target, e.quality, formatSSIM(e.index), formatSize(e.size), e.dest)
+	case "copied":
+		fmt.Fprintf(&b, "Nothing met SSIM %v while being smaller, so the original was copied to %v.", e.target, e.dest)
+	case "kept":
+		fmt.Fprintf(&b, "q%v met SSIM %v but did not win on the -keep-better cost, so the original was copied to %v.", e.quality, e.target, e.dest)
+	case "interrupted":
+		b.WriteString("The run was interrupted, so nothing was written.")
+	default:
+		fmt.Fprintf(&b, "Nothing met SSIM %v while being smaller and copying was disabled, so nothing was written.", e.target)
+	}
+	return b.String()
+}
+
+// -sidecar 写出的JSON报告
+type sidecarReport struct {
+	Source          string  `json:"source"`
+	Output          string  `json:"output"`
+	Outcome         string  `json:"outcome"`
+	Quality         int     `json:"quality,omitempty"`
+	SSIM            float64 `json:"ssim,omitempty"`
+	ColorSSIM       float64 `json:"color_ssim,omitempty"`
+	Entropy         float64 `json:"entropy,omitempty"`
+	Target          float64 `json:"target"`
+	OriginalSize    int64   `json:"original_size"`
+	FinalSize       int64   `json:"final_size"`
+	Attempts        int     `json:"attempts"`
+	MetadataKept    bool    `json:"metadata_kept"`
+	ICCProfileAdded bool    `json:"icc_profile_added"`
+	Seconds         float64 `json:"seconds"`
+}
+
+// 在输出文件旁写出 <output>.json，描述最终决定
+func writeSidecar(src string, e explanation, elapsed time.Duration) error {
+	r := sidecarReport{
+		Source:          src,
+		Output:          e.dest,
+		Outcome:         e.outcome,
+		Quality:         e.quality,
+		SSIM:            e.index,
+		ColorSSIM:       e.colorIndex,
+		Entropy:         e.entropy,
+		Target:          e.target,
+		OriginalSize:    e.sourceSize,
+		Attempts:        e.attempts,
+		ICCProfileAdded: embedSRGB && e.size > 0,
+		Seconds:         elapsed.Seconds(),
+	}
+	// 按实际写出的文件填写文件名、大小和是否含有元数据，
+	// 使 -hash-name、-strip-all 和 -verify-size 改变的结果也能反映出来
+	written := lastOutput.data
+	if written == nil {
+		data, err := os.ReadFile(lastOutput.path)
+		if err != nil {
+			return err
+		}
+		written = data
+	}
+	meta, _ := metadataSegments(written)
+	r.Output, r.FinalSize, r.MetadataKept = lastOutput.path, int64(len(written)), len(meta) > 0
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	p := e.dest + ".json"
+	if outputArchive != nil {
+		return outputArchive.add(p, data)
+	}
+	if err := prepareDir(p); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0666)
+}
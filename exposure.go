@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// 暗部和高光的亮度阈值，以及触发曝光警告的像素比例
+const (
+	shadowLevel      = 16
+	highlightLevel   = 239
+	exposureFraction = 0.3
+	exposureMinQ     = 80
+)
+
+// 统计灰阶图像中处于暗部和高光两端的像素比例
+func extremeFractions(gray image.Image) (shadows, highlights float64) {
+	b := gray.Bounds()
+	w, h := dim(gray)
+	if w*h == 0 {
+		return
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			pix := getPixVal(gray.At(x, y))
+			if pix < shadowLevel {
+				shadows++
+			} else if pix > highlightLevel {
+				highlights++
+			}
+		}
+	}
+	n := float64(w * h)
+	return shadows / n, highlights / n
+}
+
+// 图像过暗或过曝时返回建议提高最低质量的警告，否则返回空字符串
+func exposureWarning(gray image.Image, minQ int) string {
+	shadows, highlights := extremeFractions(gray)
+	var msg string
+	switch {
+	case shadows > exposureFraction:
+		msg = fmt.Sprintf("%.0f%% of pixels are near black, shadow detail may band", shadows*100)
+	case highlights > exposureFraction:
+		msg = fmt.Sprintf("%.0f%% of pixels are near white, highlight detail may band", highlights*100)
+	default:
+		return ""
+	}
+	if minQ < exposureMinQ {
+		msg += fmt.Sprintf("; consider a higher minimum quality, e.g. -min %v", exposureMinQ)
+	}
+	return msg
+}
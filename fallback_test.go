@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写出一个压缩得很好、但编码为JPEG会变大的小PNG
+func writeTinyPNG(t *testing.T, dir string) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(255)
+			if (x/2+y/2)%2 == 1 {
+				v = uint8((x*37 + y*91) % 256)
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "tiny.png")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNoFallbackEnlarge(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTinyPNG(t, dir)
+	original, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enlarged := filepath.Join(dir, "enlarged.jpg")
+	if _, stderr, code := runMain(t, "-t", "0.99999", src, enlarged); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(enlarged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) <= len(original) {
+		t.Fatalf("fixture does not enlarge: %d bytes as JPEG, %d as PNG", len(data), len(original))
+	}
+
+	copied := filepath.Join(dir, "copied.jpg")
+	stdout, stderr, code := runMain(t, "-t", "0.99999", "-no-fallback-enlarge", src, copied)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	data, err = os.ReadFile(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Errorf("wrote %d bytes, want the %d-byte original\n%s", len(data), len(original), stdout)
+	}
+}
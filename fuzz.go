@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// 质量升高时大小允许缩小的比例，超出视为违反单调性
+const fuzzSizeTolerance = 0.01
+
+// 以seed选出n个随机质量编码并测量，检查编码和解码不出错、SSIM在[0, 1]之内、
+// 大小随质量单调不减（允许fuzzSizeTolerance的误差），打印每个质量的结果和违反的不变式，
+// 返回违反的次数。违反时打印的seed可以用 -fuzz-seed 重现同样的质量
+func fuzzQualities(w io.Writer, original, reference image.Image, n int, seed int64) int {
+	rng := rand.New(rand.NewSource(seed))
+	fmt.Fprintf(w, "Fuzz seed = %v\n", seed)
+	qualities := make([]int, n)
+	for i := range qualities {
+		qualities[i] = 1 + rng.Intn(100)
+	}
+	sort.Ints(qualities)
+
+	violations := 0
+	violate := func(q int, msg string) {
+		violations++
+		fmt.Fprintf(w, "* q%v: %v (seed %v)\n", q, msg, seed)
+	}
+	prevQ, prevSize := 0, 0
+	for _, q := range qualities {
+		data, err := encodeToJPEGBytes(original, q)
+		if err != nil {
+			violate(q, "encode failed: "+err.Error())
+			continue
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			violate(q, "decode failed: "+err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "q%v: Size = %.2fKB, SSIM = %v\n", q, float32(len(data))/1024, formatSSIM(index))
+		if math.IsNaN(index) || index < 0 || index > 1 {
+			violate(q, fmt.Sprintf("SSIM %v is outside [0, 1]", index))
+		}
+		if prevSize > 0 && float64(len(data)) < float64(prevSize)*(1-fuzzSizeTolerance) {
+			violate(q, fmt.Sprintf("%v bytes is smaller than %v bytes at q%v", len(data), prevSize, prevQ))
+		}
+		prevQ, prevSize = q, len(data)
+	}
+	return violations
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFuzzQualitiesReplaysSeed(t *testing.T) {
+	original := loadFixture(t, "gradient")
+	reference := convertToGray(original)
+
+	var first, second bytes.Buffer
+	if v := fuzzQualities(&first, original, reference, 5, 42); v != 0 {
+		t.Fatalf("%d violations:\n%s", v, first.String())
+	}
+	fuzzQualities(&second, original, reference, 5, 42)
+	if first.String() != second.String() {
+		t.Errorf("same seed gave different runs:\n%s\n%s", first.String(), second.String())
+	}
+	if !strings.HasPrefix(first.String(), "Fuzz seed = 42\n") {
+		t.Errorf("output does not start with the seed:\n%s", first.String())
+	}
+}
+
+func TestFuzzViolationsPrintSeed(t *testing.T) {
+	// 尺寸不同的参考图像使每次测量都失败
+	original := loadFixture(t, "gradient")
+	reference := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	var out bytes.Buffer
+	if v := fuzzQualities(&out, original, reference, 3, 7); v != 3 {
+		t.Errorf("%d violations, want 3", v)
+	}
+	violation := regexp.MustCompile(`^\* q\d+: .* \(seed 7\)$`)
+	n := 0
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, "* ") {
+			n++
+			if !violation.MatchString(line) {
+				t.Errorf("violation %q does not name the seed", line)
+			}
+		}
+	}
+	if n != 3 {
+		t.Errorf("printed %d violations, want 3:\n%s", n, out.String())
+	}
+}
+
+func TestFuzzSeedFlag(t *testing.T) {
+	src := "testdata/gradient.png"
+	first, stderr, code := runMain(t, "-fuzz-qualities", "4", "-fuzz-seed", "99", src, "unused.jpg")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	second, _, _ := runMain(t, "-fuzz-qualities", "4", "-fuzz-seed", "99", src, "unused.jpg")
+	if !strings.Contains(first, "Fuzz seed = 99") || first != second {
+		t.Errorf("-fuzz-seed 99 did not replay the run:\n%s\n%s", first, second)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// 黄金值允许的偏差：SSIM为绝对值，大小为相对比例
+const (
+	goldenSSIMTolerance = 0.002
+	goldenSizeTolerance = 0.05
+)
+
+// 读取 testdata 中的样例图片
+func loadFixture(t *testing.T, name string) image.Image {
+	t.Helper()
+	img, err := readImage(filepath.Join("testdata", name+".png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// 判断got是否在黄金值的容差范围内
+func nearGolden(got, want float64, relative bool) bool {
+	tolerance := goldenSSIMTolerance
+	if relative {
+		tolerance = want * goldenSizeTolerance
+	}
+	return math.Abs(got-want) <= tolerance
+}
+
+// 与程序默认流程一样，对灰阶参考图像调用compare
+func TestCompareGolden(t *testing.T) {
+	tests := []struct {
+		fixture string
+		quality int
+		ssim    float64
+		size    int
+	}{
+		{"photo", 40, 0.98319, 745},
+		{"photo", 75, 0.98985, 1135},
+		{"photo", 90, 0.99483, 1919},
+		// 文字边缘的振铃使低质量下的SSIM明显下降
+		{"screenshot", 40, 0.99178, 1940},
+		{"screenshot", 75, 0.99861, 2686},
+		{"screenshot", 90, 0.99979, 3541},
+		{"gradient", 40, 0.99977, 569},
+		{"gradient", 75, 0.99989, 716},
+		{"gradient", 90, 0.99994, 904},
+		{"noise", 40, 0.95259, 2729},
+		{"noise", 75, 0.99177, 3792},
+		{"noise", 90, 0.99865, 5204},
+	}
+	for _, tt := range tests {
+		reference := convertToGray(loadFixture(t, tt.fixture))
+		index, raw, err := compare(reference, tt.quality)
+		if err != nil {
+			t.Fatalf("%s q%d: %v", tt.fixture, tt.quality, err)
+		}
+		if !nearGolden(index, tt.ssim, false) {
+			t.Errorf("%s q%d: SSIM = %.5f, want %.5f ± %v", tt.fixture, tt.quality, index, tt.ssim, goldenSSIMTolerance)
+		}
+		if !nearGolden(float64(len(raw)), float64(tt.size), true) {
+			t.Errorf("%s q%d: size = %d, want %d ± %.0f%%", tt.fixture, tt.quality, len(raw), tt.size, goldenSizeTolerance*100)
+		}
+	}
+}
+
+// 彩色编码时色度经过二次采样，照片中饱和红色边缘的Cr通道SSIM明显低于亮度
+func TestChromaSubsamplingGolden(t *testing.T) {
+	tests := []struct {
+		fixture string
+		quality int
+		luma    float64
+		cr      float64
+	}{
+		{"photo", 40, 0.98329, 0.96028},
+		{"photo", 90, 0.99479, 0.97745},
+		{"screenshot", 40, 0.99181, 0.97027},
+		{"screenshot", 90, 0.99979, 0.99872},
+	}
+	for _, tt := range tests {
+		img := loadFixture(t, tt.fixture)
+		raw, err := encodeToJPEGBytes(img, tt.quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		luma := weightedSSIM(img, decoded, &[3]float64{1, 0, 0})
+		cr := weightedSSIM(img, decoded, &[3]float64{0, 0, 1})
+		if !nearGolden(luma, tt.luma, false) {
+			t.Errorf("%s q%d: luma SSIM = %.5f, want %.5f", tt.fixture, tt.quality, luma, tt.luma)
+		}
+		if !nearGolden(cr, tt.cr, false) {
+			t.Errorf("%s q%d: Cr SSIM = %.5f, want %.5f", tt.fixture, tt.quality, cr, tt.cr)
+		}
+	}
+}
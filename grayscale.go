@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// 设置后，没有颜色的彩色图像以灰阶编码
+var autoGrayscale bool
+
+// 检测灰阶内容时采样的大致像素数
+const graySamples = 1 << 16
+
+// 各分量之差不超过该值（0-255）的像素视为灰色，容许JPEG色度量化带来的偏差
+const grayTolerance = 3
+
+// 判断以彩色存储的图像是否只有灰阶内容，均匀采样约graySamples个像素。
+// 已经是灰阶类型的图像返回false
+func looksGray(img image.Image) bool {
+	if _, ok := img.(*image.Gray); ok {
+		return false
+	}
+	b := img.Bounds()
+	step := int(math.Ceil(math.Sqrt(float64(b.Dx()*b.Dy()) / graySamples)))
+	if step < 1 {
+		step = 1
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			if ycc, ok := img.(*image.YCbCr); ok {
+				c := ycc.COffset(x, y)
+				if !nearGray(int(ycc.Cb[c]), 128, 128) || !nearGray(int(ycc.Cr[c]), 128, 128) {
+					return false
+				}
+				continue
+			}
+			r, g, bl, _ := img.At(x, y).RGBA()
+			if !nearGray(int(r>>8), int(g>>8), int(bl>>8)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 判断三个分量是否在grayTolerance之内
+func nearGray(a, b, c int) bool {
+	lo, hi := a, a
+	for _, v := range []int{b, c} {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	return hi-lo <= grayTolerance
+}
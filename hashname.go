@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"path/filepath"
+)
+
+// 按内容哈希命名输出时使用的算法和保留的十六进制位数，算法为空时不重命名
+var (
+	hashAlgo   string
+	hashLength = 12
+)
+
+// 支持的哈希算法
+var hashAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// 返回以数据哈希命名、与原路径同目录同扩展名的路径，如 out/a.jpg -> out/1f2e3d4c5b6a.jpg
+func hashedPath(p string, data []byte) string {
+	h := hashAlgos[hashAlgo]()
+	h.Write(data)
+	sum := hex.EncodeToString(h.Sum(nil))
+	if hashLength > 0 && hashLength < len(sum) {
+		sum = sum[:hashLength]
+	}
+	return filepath.Join(filepath.Dir(p), sum+filepath.Ext(p))
+}
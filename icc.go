@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// 源图片没有ICC配置文件时，是否在输出中嵌入sRGB配置文件
+var embedSRGB bool
+
+// 将数值编码为ICC的s15Fixed16Number
+func s15Fixed16(v float64) uint32 {
+	return uint32(int32(v * 65536))
+}
+
+// 生成一个最小的ICC v2 sRGB配置文件：D50白点、经Bradford适配的sRGB原色和2.2伽马曲线
+func srgbProfile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = binary.BigEndian.AppendUint32(b, s15Fixed16(v))
+		}
+		return b
+	}
+
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len("sRGB")+1))
+	desc = append(desc, "sRGB\x00"...)
+	// 空的Unicode和ScriptCode描述
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+
+	curve := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// 头部128字节，之后是标签表，标签数据按4字节对齐
+	offset := 128 + 4 + 12*len(tags)
+	var table, body []byte
+	table = binary.BigEndian.AppendUint32(table, uint32(len(tags)))
+	for _, t := range tags {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+		body = append(body, t.data...)
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+len(table)+len(body)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[36:], "acsp")
+	binary.BigEndian.PutUint32(header[68:], s15Fixed16(0.9642))
+	binary.BigEndian.PutUint32(header[72:], s15Fixed16(1.0))
+	binary.BigEndian.PutUint32(header[76:], s15Fixed16(0.8249))
+
+	return append(append(header, table...), body...)
+}
+
+// 判断JPEG数据中是否已有ICC配置文件(APP2 ICC_PROFILE)
+func hasICCProfile(data []byte) bool {
+	segments, _ := jpegSegments(data)
+	for _, seg := range segments {
+		if seg.marker == 0xe2 && bytes.HasPrefix(seg.data, []byte("ICC_PROFILE\x00")) {
+			return true
+		}
+	}
+	return false
+}
+
+// 在SOI之后插入包含ICC配置文件的APP2标记段
+func withICCProfile(data, profile []byte) []byte {
+	payload := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	return withSegments(data, []jpegSegment{{0xe2, payload}})
+}
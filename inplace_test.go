@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFileSamePath(t *testing.T) {
+	data := []byte("original bytes")
+	src := writeSource(t, "same.jpg", data)
+	// 写法不同但指向同一文件的路径也要识别出来
+	alias := filepath.Join(filepath.Dir(src), ".", "..", filepath.Base(filepath.Dir(src)), "same.jpg")
+	for _, dest := range []string{src, alias} {
+		if _, err := copyFile(src, dest); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("copyFile(%q, %q) left %q", src, dest, got)
+		}
+	}
+}
+
+func TestInPlaceOverwrite(t *testing.T) {
+	photo := loadFixture(t, "photo")
+	tests := []struct {
+		name    string
+		quality int
+		args    []string
+		copied  bool
+	}{
+		// 无法在更小的同时达到目标，复制原图到自身
+		{"copied", 40, []string{"-t", "0.99999"}, true},
+		{"optimized", 100, []string{"-t", "0.99"}, false},
+	}
+	for _, tt := range tests {
+		data, err := encodeToJPEGBytes(photo, tt.quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := writeSource(t, tt.name+".jpg", data)
+		args := append(append([]string{"-f"}, tt.args...), src, src)
+		if _, stderr, code := runMain(t, args...); code != 0 {
+			t.Fatalf("%s: exit %d: %s", tt.name, code, stderr)
+		}
+		got, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.copied {
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s: source changed (%d bytes, was %d)", tt.name, len(got), len(data))
+			}
+			continue
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+			t.Errorf("%s: overwritten source does not decode: %v", tt.name, err)
+		}
+		if len(got) >= len(data) {
+			t.Errorf("%s: overwritten source is %d bytes, original %d", tt.name, len(got), len(data))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 判断标准输入输出是否都连接到终端
+func isTerminal() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 交互式选择质量：输入+/-调整质量或直接输入质量，回车保存，q放弃。
+// 返回所选质量编码后的数据，放弃时ok为false
+func pickQuality(original, reference image.Image, originalSize int64, quality int) (data []byte, q int, ok bool) {
+	q = quality
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		raw, err := encodeToJPEGBytes(original, q)
+		if err != nil {
+			panic(err)
+		}
+		index, err := measure(reference, raw)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Quality = %v, SSIM = %v, Size = %.2fKB (%.1f%% of original) [+/-/quality, enter to save, q to quit]: ",
+			q, formatSSIM(index), float32(len(raw))/1024, float32(len(raw))/float32(originalSize)*100)
+
+		if !in.Scan() {
+			fmt.Println()
+			return nil, q, false
+		}
+		switch cmd := strings.TrimSpace(in.Text()); cmd {
+		case "":
+			return raw, q, true
+		case "q":
+			return nil, q, false
+		case "+":
+			if q < 100 {
+				q++
+			}
+		case "-":
+			if q > 1 {
+				q--
+			}
+		default:
+			n, err := strconv.Atoi(cmd)
+			if err != nil || n < 1 || n > 100 {
+				fmt.Println("* Quality has to be between 1 and 100.")
+				continue
+			}
+			q = n
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jpegenc
+
+import (
+	"bufio"
+	"image"
+	"io"
+)
+
+// optimalHuffmanTables runs the scan once to count the Huffman symbols that
+// encoding m with the quantization tables quant produces, and returns tables
+// built from those counts. Tables for which no symbols were counted (the
+// chrominance tables of a grayscale image) keep the standard specification.
+func optimalHuffmanTables(m image.Image, quant [nQuantIndex][blockSize]byte, restartInterval int) (*[nHuffIndex]huffmanSpec, *[nHuffIndex]huffmanLUT) {
+	c := encoder{
+		w:               bufio.NewWriter(io.Discard),
+		quant:           quant,
+		restartInterval: restartInterval,
+		freq:            new([nHuffIndex][257]int64),
+	}
+	c.writeSOS(m)
+
+	specs := theHuffmanSpec
+	var luts [nHuffIndex]huffmanLUT
+	for i := range specs {
+		if used(c.freq[i][:256]) {
+			specs[i] = optimalHuffmanSpec(&c.freq[i])
+		}
+		luts[i].init(specs[i])
+	}
+	return &specs, &luts
+}
+
+// used reports whether any symbol has a non-zero count.
+func used(freq []int64) bool {
+	for _, f := range freq {
+		if f != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// optimalHuffmanSpec builds a Huffman specification for the given symbol
+// counts, following section K.2 of the spec. freq[256] is a reserved symbol
+// that guarantees no real symbol is assigned the all-ones code; freq is
+// modified.
+func optimalHuffmanSpec(freq *[257]int64) huffmanSpec {
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	freq[256] = 1
+
+	for {
+		// Find the two least frequent symbols, preferring the larger
+		// symbol value when counts tie.
+		c1, c2 := -1, -1
+		var v1, v2 int64
+		for i, f := range freq {
+			if f == 0 {
+				continue
+			}
+			if c1 < 0 || f <= v1 {
+				c2, v2 = c1, v1
+				c1, v1 = i, f
+			} else if c2 < 0 || f <= v2 {
+				c2, v2 = i, f
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		// Merge the two trees.
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codeSize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codeSize[c1]++
+		}
+		others[c1] = c2
+		codeSize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codeSize[c2]++
+		}
+	}
+
+	// Count the codes of each length.
+	var bits [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+
+	// Limit the code lengths to 16 bits.
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+
+	// Remove the reserved symbol's code, which is the longest.
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var s huffmanSpec
+	for length := 1; length <= 16; length++ {
+		s.count[length-1] = byte(bits[length])
+	}
+	for length := 1; length <= 32; length++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if codeSize[symbol] == length {
+				s.value = append(s.value, byte(symbol))
+			}
+		}
+	}
+	return s
+}
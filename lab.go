@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// 计算SSIM的色彩空间，luma为灰阶，lab为CIELAB
+var ssimSpace = "luma"
+
+// sRGB分量到线性值的查找表
+var srgbToLinear [256]float64
+
+func init() {
+	for i := range srgbToLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			srgbToLinear[i] = c / 12.92
+		} else {
+			srgbToLinear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+}
+
+// CIELAB转换中的非线性函数
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27.0*t + 16) / 116
+}
+
+// 将sRGB颜色转换为CIELAB（D65白点）
+func rgbToLab(r, g, b uint8) (l, a, bb float64) {
+	lr, lg, lb := srgbToLinear[r], srgbToLinear[g], srgbToLinear[b]
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / 0.95047
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// 将图像拆分为L*、a*、b*三个通道，并映射到0-255以沿用SSIM常量
+func splitLab(img image.Image) [3]*image.Gray {
+	bounds := img.Bounds()
+	var planes [3]*image.Gray
+	for i := range planes {
+		planes[i] = image.NewGray(bounds)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			l, a, b := rgbToLab(c.R, c.G, c.B)
+			planes[0].SetGray(x, y, color.Gray{Y: clampByte(l * 2.55)})
+			planes[1].SetGray(x, y, color.Gray{Y: clampByte(a + 128)})
+			planes[2].SetGray(x, y, color.Gray{Y: clampByte(b + 128)})
+		}
+	}
+	return planes
+}
+
+// 将浮点数四舍五入并限制在0-255之间
+func clampByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
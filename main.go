@@ -1,16 +1,46 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"image"
 	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"syscall"
+	"time"
+
+	"jpeg-recompress/jpegenc"
 )
 
+// 解析命令行参数，位置参数前后都可以有选项，"--" 之后的参数都视为位置参数。返回位置参数
+func parseArgs(args []string) []string {
+	var positional []string
+	for len(args) > 0 {
+		flag.CommandLine.Parse(args)
+		rest := flag.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	return positional
+}
+
 // 检查命令行参数
 func checkArgs(src string, dest string, force bool, max int, min int, target float64, loops int) bool {
 	var msg string
-	if _, err := os.Stat(src); os.IsNotExist(err) {
+	if _, err := os.Stat(src); os.IsNotExist(err) && !isURL(src) {
 		msg = "Source image '" + src + "' does not exists."
 	}
 	if !force {
@@ -41,62 +71,958 @@ func checkArgs(src string, dest string, force bool, max int, min int, target flo
 	return false
 }
 
+// 打印参数错误和帮助信息后退出
+func usageError(msg string) {
+	printUsageError(msg)
+	os.Exit(1)
+}
+
+// 打印参数错误和帮助信息但不退出。用于注册了归档、性能分析等defer之后的检查，
+// 调用者设置退出码后返回，使这些defer仍能执行
+func printUsageError(msg string) {
+	fmt.Fprintln(os.Stderr, "* Error: "+msg+"\n")
+	flag.Usage()
+}
+
+// 被SIGINT或SIGTERM中断时的退出码
+const interruptedExitCode = 130
+
 func main() {
+	// 最先注册，在其他defer（归档、性能分析）执行完后再以非零退出码退出
+	exitCode := 0
+	defer func() {
+		if strict && warnings > 0 && exitCode == 0 {
+			exitCode = 1
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	var (
 		minQ, maxQ          int
 		target              float64
 		loops               int
 		help, force, noCopy bool
+		phash               bool
+		qtables             string
+		timeBudget          time.Duration
+		verbose             bool
+		fetchLimitMB        int
+		ab                  string
+		interactiveMode     bool
+		weights             string
+		seed                bool
+		archive             string
+		cpuprofile          string
+		memprofile          string
+		minimizeSize        bool
+		payloadSize         bool
+		minPSNR             float64
+		separateChroma      bool
+		chromaTarget        float64
+		verifySize          bool
+		optimize            bool
+		pin                 string
+		explain             bool
+		preserveTimes       bool
+		calibrate           string
+		maxDrop             float64
+		skipDimensions      string
+		checkDeterminism    bool
+		chmod               string
+		embedProfile        bool
+		compareDir          bool
+		dither              bool
+		bpp                 bool
+		pdfReport           bool
+		targetBPP           float64
+		keepBetter          bool
+		qualityWeight       float64
+		watch               bool
+		watchInterval       time.Duration
+		costSpec            string
+		sidecar             bool
+		backendQuality      int
+		adaptive            bool
+		adaptiveSpread      float64
+		metadataFrom        string
+		cacheDir            string
+		widthList           string
+		exifFilter          string
+		tiers               string
+		abortOnLarger       bool
+		reportColor         bool
+		printQuality        bool
+		noFallbackEnlarge   bool
+		restartInterval     int
+		ssimBenchQuality    int
+		fuzzCount           int
+		fuzzSeed            int64
+		showEntropy         bool
 	)
 
 	flag.IntVar(&maxQ, "max", 95, "Maximum quality")
 	flag.IntVar(&minQ, "min", 40, "Minimum quality")
 	flag.Float64Var(&target, "t", 0.99995, "Set the target SSIM")
+	flag.Float64Var(&maxDrop, "max-drop", 0, "Set the target as the largest allowed SSIM drop from 1.0, e.g. 0.0001 (overrides -t)")
 	flag.IntVar(&loops, "l", 6, "Maximum number of attempts to find the best quality")
 	flag.BoolVar(&help, "h", false, "Print this help message")
-	flag.Bool("f", false, "Overwrite the output image if it already exists")
-	flag.Bool("c", false, "Disable copying files that will not be compressed")
-	flag.Parse()
-
-	src, dest := flag.Arg(0), flag.Arg(1)
-
-	for _, n := range os.Args {
-		if n == "-f" {
-			force = true
-		} else if n == "-c" {
-			noCopy = true
-		}
-	}
-
+	flag.StringVar(&cpuprofile, "cpuprofile", "", "Write a CPU profile to this file")
+	flag.StringVar(&memprofile, "memprofile", "", "Write a memory profile to this file when done")
+	flag.BoolVar(&quiet, "q", false, "Don't print warnings")
+	flag.BoolVar(&printQuality, "print-quality", false, "Print only the chosen quality to stdout, one line per written output (each -widths width or -ab version), nothing when the original is copied (still writes dest)")
+	flag.StringVar(&calibrate, "calibrate", "", "Measure the SSIM of the given quality (e.g. q=80) and suggest it as -t, without saving")
+	flag.StringVar(&skipDimensions, "skip-dimensions", "", "Copy images no larger than WxH (e.g. 64x64) without recompressing")
+	flag.BoolVar(&checkDeterminism, "check-determinism", false, "Encode the final quality a second time and report if the bytes differ")
+	flag.StringVar(&searchStrategy, "strategy", searchStrategy, "How to pick the next quality: binary (midpoint) or golden (golden-section point)")
+	flag.BoolVar(&lowMemory, "low-memory", false, "Measure SSIM in row strips straight from the decoded candidate, without a grayscale copy")
+	flag.BoolVar(&pdfReport, "pdf-report", false, "Treat src as a PDF and report how much recompressing its embedded JPEGs would save, without writing anything")
+	flag.Float64Var(&targetBPP, "target-bpp", 0, "Use the highest quality whose output fits in this many bits per pixel, or a lower one if it already meets -t")
+	flag.BoolVar(&keepBetter, "keep-better", false, "Keep the original JPEG unless the output wins on size + weight * (1 - SSIM) * original size")
+	flag.Float64Var(&qualityWeight, "quality-weight", 1000, "Weight of the SSIM loss in the -keep-better cost")
+	flag.BoolVar(&watch, "watch", false, "Treat src as a directory to watch and recompress new or changed images into the dest directory until interrupted")
+	flag.DurationVar(&watchInterval, "watch-interval", time.Second, "How often -watch polls the directory")
+	flag.StringVar(&costSpec, "cost", "", "Minimize size+LAMBDA*(1-ssim) (size in bytes) instead of meeting -t; presets: small (1e7), balanced (1e8), quality (1e9)")
+	flag.BoolVar(&sidecar, "sidecar", false, "Write <dest>.json describing the decision (quality, SSIM, sizes, timing)")
+	flag.IntVar(&ssimBenchQuality, "compare-ssim", 0, "Development: encode at this quality and compare the score and time of each SSIM implementation, without saving")
+	flag.IntVar(&fuzzCount, "fuzz-qualities", 0, "Development: encode at this many random qualities and report any failed encode or decode, SSIM outside [0, 1] or size shrinking as quality rises, without saving")
+	flag.Int64Var(&fuzzSeed, "fuzz-seed", 0, "Seed for -fuzz-qualities, to replay the qualities of an earlier run (0 picks a new seed)")
+	flag.IntVar(&backendQuality, "compare-backends", 0, "Encode at this quality with every available encoder and compare size and SSIM, without saving")
+	flag.BoolVar(&adaptive, "adaptive-target", false, "Experimental: move the target within -adaptive-spread of -t, lower for flat images and higher for detailed ones")
+	flag.Float64Var(&adaptiveSpread, "adaptive-spread", 0.00004, "Largest change -adaptive-target makes to -t")
+	flag.BoolVar(&stripAll, "strip-all", false, "Remove every APPn and COM segment except JFIF and Adobe from all written JPEGs, including copied originals")
+	flag.StringVar(&metadataFrom, "copy-metadata-from", "", "Copy EXIF, XMP, IPTC and comments from this JPEG into the output")
+	flag.StringVar(&cacheDir, "cache", "", "Reuse earlier results for the same source bytes and options from this directory, and store new ones there")
+	flag.StringVar(&widthList, "widths", "", "Comma-separated widths (e.g. 480,960,1920) to downscale to and search separately, saved as dest.w480.jpg and so on")
+	flag.StringVar(&resizeFilter, "resize-filter", resizeFilter, "Resampling filter for -widths: bilinear, box (area average), nearest, bicubic or lanczos")
+	flag.StringVar(&exifFilter, "filter", "", "Only process JPEGs whose EXIF matches, e.g. exif.DateTime>=2021 or exif.Model=X100V. Fields: Make, Model, DateTime, DateTimeOriginal; operators: = != > >= < <= (string comparison)")
+	flag.StringVar(&tiers, "tier", "", "Pick -t by width, first match wins, e.g. 'w<=480:t=0.995,w<=1920:t=0.9999' (operators < <= > >=)")
+	flag.BoolVar(&noFallbackEnlarge, "no-fallback-enlarge", false, "For non-JPEG sources, copy the original instead of writing a closest-match fallback that is larger than it")
+	flag.BoolVar(&abortOnLarger, "abort-on-larger", false, "Encode the lowest and highest qualities first and skip the search when both are larger than the original (up to two extra encodes)")
+	flag.BoolVar(&reportColor, "color-ssim", false, "Also report the per-channel color SSIM of the final output next to the luma SSIM the search used")
+	flag.BoolVar(&showEntropy, "entropy", false, "Report the Shannon entropy of the source's gray level histogram (0-8 bits per pixel, high for noisy images)")
+	flag.BoolVar(&bpp, "bpp", false, "Show bits per pixel next to each size")
+	flag.BoolVar(&autoGrayscale, "auto-grayscale", false, "Encode color images whose pixels are all gray as grayscale JPEGs")
+	flag.BoolVar(&dither, "dither", false, "Apply ordered dithering when reducing a high bit depth source to 8 bits, to avoid banding")
+	flag.BoolVar(&strict, "strict", false, "Exit with 1 if any warning was printed, e.g. exposure, time budget or fallback to the closest match")
+	flag.BoolVar(&compareDir, "compare-dir", false, "Treat src and dest as an originals and an outputs directory and report SSIM and size for each matching pair")
+	flag.BoolVar(&embedProfile, "embed-srgb", false, "Embed a small sRGB ICC profile in the output when the source has no profile")
+	flag.StringVar(&chmod, "chmod", "", "Set the output file permissions in octal, e.g. 0644, regardless of umask")
+	flag.BoolVar(&preserveTimes, "preserve-times", false, "Give the output the same modification time as the source")
+	flag.BoolVar(&explain, "explain", false, "Finish with a short paragraph explaining the final decision")
+	flag.BoolVar(&verbose, "v", false, "Explain how each attempt moves the search bounds")
+	flag.BoolVar(&force, "f", false, "Overwrite the output image if it already exists")
+	flag.BoolVar(&noCopy, "c", false, "Disable copying files that will not be compressed")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", fetchTimeout, "Timeout for downloading an http(s) source")
+	flag.Int64Var(&maxPixels, "max-pixels", maxPixels, "Refuse sources with more pixels than this, checked before decoding")
+	flag.IntVar(&fetchLimitMB, "fetch-limit", int(fetchLimit>>20), "Maximum size in MB of an http(s) source")
+	flag.DurationVar(&timeBudget, "time-budget", 0, "Stop searching once the run has taken this long, e.g. 5m (0 disables)")
+	flag.StringVar(&hashAlgo, "hash-name", "", "Name outputs after a hash of their bytes in dest's directory: md5, sha1 or sha256")
+	flag.IntVar(&hashLength, "hash-length", hashLength, "Number of hex digits of the hash kept for -hash-name (0 keeps all)")
+	flag.BoolVar(&makeDirs, "mkdir", false, "Create the destination directory if it doesn't exist")
+	flag.StringVar(&archive, "archive", "", "Write the output as an entry named dest inside this .zip or .tar archive")
+	flag.BoolVar(&verifySize, "verify-size", false, "Re-check the written output and copy the original instead if it ended up larger")
+	flag.BoolVar(&separateChroma, "separate-chroma", false, "After the search, lower the chroma quality on its own while the chroma SSIM meets -chroma-target")
+	flag.Float64Var(&chromaTarget, "chroma-target", 0.999, "Target SSIM of the chroma channels for -separate-chroma")
+	flag.Float64Var(&minPSNR, "min-psnr", 0, "Never accept a quality whose PSNR is below this many dB, e.g. 38 (0 disables)")
+	flag.BoolVar(&payloadSize, "payload-size", false, "Compare against the original's size without its metadata (APPn/COM segments)")
+	flag.BoolVar(&minimizeSize, "minimize-size", false, "After the search, keep lowering the quality while the target is still met to find the smallest file")
+	flag.BoolVar(&seed, "seed", true, "Start the search at the estimated quality of a JPEG source instead of the middle of the range")
+	flag.IntVar(&borderCrop, "crop-border", 0, "Leave this many pixels around the edges out of the SSIM measurement")
+	flag.IntVar(&windowSize, "window", 0, "Average SSIM over windows of this size, e.g. 8 (0 compares whole images)")
+	flag.StringVar(&windowAcceptance, "acceptance", windowAcceptance, "Window SSIM statistic compared against the target: mean, min or p5 (needs -window)")
+	flag.IntVar(&tileRows, "tile-rows", 0, "Experimental: compute SSIM in horizontal strips of this many rows (0 disables)")
+	flag.StringVar(&weights, "channel-weights", "", "Measure SSIM on the Y,Cb,Cr (or L*,a*,b* with -ssim-space lab) channels with these weights, e.g. 6,1,1 (default luma only)")
+	flag.StringVar(&ssimDisplay, "ssim-display", ssimDisplay, "Show SSIM values as linear, db (-10*log10(1-SSIM)) or percent")
+	flag.StringVar(&metric, "metric", metric, "Score compared against -t: ssim, or pixel-diff (the fraction of pixels whose gray level changed by less than -pixel-threshold)")
+	flag.IntVar(&pixelThreshold, "pixel-threshold", pixelThreshold, "Gray level change (out of 255) below which -metric pixel-diff counts a pixel as unchanged")
+	flag.StringVar(&ssimSpace, "ssim-space", ssimSpace, "Color space to measure SSIM in: luma or lab")
+	flag.BoolVar(&interactiveMode, "interactive", false, "Pick the quality by hand, re-encoding as it is adjusted")
+	flag.StringVar(&pin, "pin", "", "Skip the search for listed files and use a fixed quality, e.g. photo.jpg=90,logo.png=100")
+	flag.StringVar(&ab, "ab", "", "Skip the search and write one output per listed quality for A/B testing, e.g. 80,90")
+	flag.BoolVar(&optimize, "optimize", false, "Write Huffman tables optimized for each image (lossless, usually smaller)")
+	flag.IntVar(&restartInterval, "restart-interval", 0, "Write a restart marker every this many MCUs so decoders can recover from corrupt data (0 disables)")
+	flag.StringVar(&qtables, "qtables", "", "JSON file with custom luma/chroma 8x8 quantization tables, scaled by quality (50 uses them as given)")
+	flag.BoolVar(&phash, "phash", false, "Report the perceptual hash (dHash) drift between the original and the output")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: ./jpeg-recompress src dest [options]")
 		fmt.Fprintln(os.Stderr, "All metadata will be lost during this process")
 		fmt.Fprintln(os.Stderr, "If no match is found, the original webp image will be copied over, otherwise it will use the quality that produces the lowest and closest size to the original")
+		fmt.Fprintln(os.Stderr, "A file named <src>.target containing just a number overrides -t for that image")
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintln(os.Stderr, "Options:")
 		flag.PrintDefaults()
 	}
 
+	args := parseArgs(os.Args[1:])
+	started := time.Now()
+
+	var src, dest string
+	if len(args) > 0 {
+		src = args[0]
+	}
+	if len(args) > 1 {
+		dest = args[1]
+	}
+
 	if help {
 		flag.Usage()
 		return
 	}
 
-	if !checkArgs(src, dest, force, maxQ, minQ, target, loops) {
+	// 只输出最终质量：其余输出都丢弃，结束时把每个写出的输出所用的质量
+	// 按写出的顺序逐行写到真正的标准输出（-widths 每个宽度一行，-ab 每个版本一行）
+	var chosenQualities []int
+	if printQuality {
+		qualityOut := os.Stdout
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			panic(err)
+		}
+		os.Stdout = devNull
+		quiet = true
+		defer func() {
+			for _, q := range chosenQualities {
+				fmt.Fprintln(qualityOut, q)
+			}
+		}()
+	}
+
+	if qualityWeight < 0 {
+		usageError("Quality weight can't be negative.")
+	}
+
+	var costLambda float64
+	if costSpec != "" {
+		lambda, err := parseCost(costSpec)
+		if err != nil {
+			usageError("Invalid -cost: " + err.Error() + ".")
+		}
+		costLambda = lambda
+	}
+
+	if stripAll && (metadataFrom != "" || embedProfile) {
+		usageError("Metadata can't be copied or embedded with -strip-all.")
+	}
+
+	if metadataFrom != "" {
+		data, err := readSource(metadataFrom)
+		if err != nil {
+			usageError("Can't read metadata source '" + metadataFrom + "': " + err.Error() + ".")
+		}
+		segments, err := metadataSegments(data)
+		if err != nil {
+			usageError("Can't read metadata source '" + metadataFrom + "': " + err.Error() + ".")
+		}
+		copiedMetadata = segments
+	}
+
+	if adaptiveSpread < 0 || adaptiveSpread >= 1 {
+		usageError("Adaptive spread has to be between 0 and 1.")
+	}
+
+	if backendQuality < 0 || backendQuality > 100 {
+		usageError("Backend comparison quality has to be between 1 and 100.")
+	}
+
+	if ssimBenchQuality < 0 || ssimBenchQuality > 100 {
+		usageError("SSIM comparison quality has to be between 1 and 100.")
+	}
+
+	if fuzzCount < 0 {
+		usageError("Number of fuzz qualities can't be negative.")
+	}
+
+	if targetBPP < 0 {
+		usageError("Target BPP can't be negative.")
+	}
+
+	if maxDrop != 0 {
+		if maxDrop < 0 || maxDrop >= 1 {
+			usageError("Max drop has to be between 0 and 1.")
+		}
+		target = 1 - maxDrop
+	}
+
+	// 审计模式：不编码，只比较两个目录中对应的图片
+	if compareDir {
+		flagged, err := compareDirs(os.Stdout, src, dest, target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "* Error: "+err.Error())
+			os.Exit(1)
+		}
+		if flagged > 0 {
+			fmt.Printf("* %v file(s) below SSIM %v or not comparable\n", flagged, target)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 监视模式：每个文件由子进程按其余参数处理
+	if watch {
+		if info, err := os.Stat(src); err != nil || !info.IsDir() {
+			usageError("Watch source '" + src + "' is not a directory.")
+		}
+		if dest == "" {
+			usageError("Please specify an output directory for -watch.")
+		}
+		if watchInterval <= 0 {
+			usageError("Watch interval has to be more than 0.")
+		}
+		if err := watchDir(src, dest, watchInterval); err != nil {
+			fmt.Fprintln(os.Stderr, "* Error: "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// PDF报告模式：只读，不需要输出路径
+	if pdfReport {
+		if !checkArgs(src, "-", true, maxQ, minQ, target, loops) {
+			flag.Usage()
+			os.Exit(1)
+		}
+		if err := reportPDF(os.Stdout, src, target, minQ, maxQ, loops); err != nil {
+			fmt.Fprintln(os.Stderr, "* Error: "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !checkArgs(src, dest, force || archive != "" || calibrate != "" || backendQuality > 0 || ssimBenchQuality > 0 || fuzzCount > 0, maxQ, minQ, target, loops) {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if fetchLimitMB <= 0 {
+		usageError("Fetch limit has to be more than 0.")
+	}
+	fetchLimit = int64(fetchLimitMB) << 20
+
+	if chmod != "" {
+		mode, err := strconv.ParseUint(chmod, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			usageError("Chmod has to be an octal permission between 0001 and 0777.")
+		}
+		outputMode = os.FileMode(mode)
+	}
+
+	var skipW, skipH int
+	if skipDimensions != "" {
+		w, h, err := parseDimensions(skipDimensions)
+		if err != nil {
+			usageError("Invalid -skip-dimensions: " + err.Error() + ".")
+		}
+		skipW, skipH = w, h
+	}
+
+	var widths []int
+	if widthList != "" {
+		var err error
+		if widths, err = parseWidths(widthList); err != nil {
+			usageError("Invalid -widths: " + err.Error() + ".")
+		}
+	}
+	if !validResizeFilter(resizeFilter) {
+		usageError("Resize filter has to be box, nearest, bilinear, bicubic or lanczos.")
+	}
+
+	if exifFilter != "" {
+		pred, err := parseExifFilter(exifFilter)
+		if err != nil {
+			usageError("Invalid -filter: " + err.Error() + ".")
+		}
+		data, err := readSource(src)
+		if err != nil {
+			panic(err)
+		}
+		if !pred.match(readExif(data)) {
+			fmt.Printf("Skipping %v, its EXIF doesn't match %v\n", src, exifFilter)
+			return
+		}
+	}
+
+	var calibrateQ int
+	if calibrate != "" {
+		q, err := parseCalibration(calibrate)
+		if err != nil {
+			usageError("Invalid -calibrate: " + err.Error() + ".")
+		}
+		calibrateQ = q
+	}
+
+	if maxPixels <= 0 {
+		usageError("Max pixels has to be more than 0.")
+	}
+
+	if timeBudget < 0 {
+		usageError("Time budget can't be negative.")
+	}
+	var deadline time.Time
+	if timeBudget > 0 {
+		deadline = time.Now().Add(timeBudget)
+	}
+
+	if tiers != "" {
+		rules, err := parseTiers(tiers)
+		if err != nil {
+			usageError("Invalid -tier: " + err.Error() + ".")
+		}
+		if cfg, _, err := readConfig(src); err == nil {
+			if t, ok := tierTarget(rules, cfg.Width); ok {
+				target = t
+				fmt.Printf("Target SSIM = %v (tier for width %v)\n", target, cfg.Width)
+			}
+		}
+	}
+
+	if t, ok, err := readTargetSidecar(src); err != nil {
+		usageError("Invalid target in '" + src + ".target': " + err.Error())
+	} else if ok {
+		target = t
+		fmt.Printf("Target SSIM = %v (from %v.target)\n", target, src)
+	}
+
+	if _, ok := hashAlgos[hashAlgo]; hashAlgo != "" && !ok {
+		usageError("Hash algorithm has to be md5, sha1 or sha256.")
+	}
+	if hashLength < 0 {
+		usageError("Hash length can't be negative.")
+	}
+	if chromaTarget <= 0 || chromaTarget > 1 {
+		usageError("Chroma target has to be more than 0 and at most 1.")
+	}
+	if minPSNR < 0 {
+		usageError("Minimum PSNR can't be negative.")
+	}
+	if windowSize < 0 {
+		usageError("Window size can't be negative.")
+	}
+	if windowAcceptance != "mean" && windowAcceptance != "min" && windowAcceptance != "p5" {
+		usageError("Acceptance has to be mean, min or p5.")
+	}
+	if windowAcceptance != "mean" && windowSize == 0 {
+		usageError("Acceptance " + windowAcceptance + " needs -window.")
+	}
+	if tileRows < 0 {
+		usageError("Tile rows can't be negative.")
+	}
+
+	if ssimSpace != "luma" && ssimSpace != "lab" {
+		usageError("SSIM space has to be luma or lab.")
+	}
+
+	if metric != "ssim" && metric != "pixel-diff" {
+		usageError("Metric has to be ssim or pixel-diff.")
+	}
+	if pixelThreshold < 1 || pixelThreshold > 255 {
+		usageError("Pixel threshold has to be between 1 and 255.")
+	}
+
+	if searchStrategy != "binary" && searchStrategy != "golden" {
+		usageError("Strategy has to be binary or golden.")
+	}
+
+	if ssimDisplay != "linear" && ssimDisplay != "db" && ssimDisplay != "percent" {
+		usageError("SSIM display has to be linear, db or percent.")
+	}
+
+	if weights != "" {
+		var err error
+		channelWeights, err = parseChannelWeights(weights)
+		if err != nil {
+			usageError("Invalid channel weights '" + weights + "': " + err.Error())
+		}
+	}
+	if metric == "pixel-diff" && perChannel() {
+		usageError("Pixel diff only compares gray levels, it can't be used with -channel-weights or -ssim-space lab.")
+	}
+
+	var pins map[string]int
+	if pin != "" {
+		var err error
+		pins, err = parsePins(pin)
+		if err != nil {
+			usageError("Invalid pinned qualities '" + pin + "': " + err.Error())
+		}
+	}
+
+	var abQualities []int
+	if ab != "" {
+		var err error
+		abQualities, err = parseQualities(ab)
+		if err != nil {
+			usageError("Invalid A/B qualities '" + ab + "': " + err.Error())
+		}
+	}
+
+	if optimize {
+		customOptions().OptimizeHuffman = true
+	}
+	if qtables != "" {
+		tables, err := loadQuantTables(qtables)
+		if err != nil {
+			usageError("Invalid quantization tables '" + qtables + "': " + err.Error())
+		}
+		customOptions().QuantTables = tables
+	}
+	if restartInterval != 0 {
+		if restartInterval < 0 || restartInterval >= 1<<16 {
+			usageError("Restart interval has to be between 0 and 65535.")
+		}
+		customOptions().RestartInterval = restartInterval
+	}
+
+	if _, err := os.Stat(filepath.Dir(dest)); archive == "" && !makeDirs && os.IsNotExist(err) {
+		usageError("Destination directory '" + filepath.Dir(dest) + "' does not exist. Use -mkdir to create it.")
+	}
+
+	if _, err := cleanRelative(dest); archive != "" && err != nil {
+		usageError("Destination '" + dest + "' would be written outside of the archive root.")
+	}
+
+	if _, err := os.Stat(archive); archive != "" && err == nil && !force {
+		usageError("Archive '" + archive + "' already exists. Use -f to overwrite.")
+	}
+
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			usageError("Can't create CPU profile '" + cpuprofile + "': " + err.Error())
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			printUsageError("Can't start CPU profile: " + err.Error())
+			exitCode = 1
+			return
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if memprofile != "" {
+		defer func() {
+			f, err := os.Create(memprofile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "* Error: can't create memory profile: "+err.Error())
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintln(os.Stderr, "* Error: can't write memory profile: "+err.Error())
+			}
+		}()
+	}
+
+	// 从这里开始性能分析的defer已注册，失败时设置退出码后返回，不能直接退出
+	originalSize, err := getFilesize(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "* Error: "+err.Error())
+		exitCode = 1
+		return
+	}
+
+	if preserveTimes && !isURL(src) {
+		if timesFrom, err = os.Stat(src); err != nil {
+			panic(err)
+		}
+	}
+
+	if archive != "" {
+		a, err := createArchive(archive)
+		if err != nil {
+			printUsageError("Can't create archive '" + archive + "': " + err.Error())
+			exitCode = 1
+			return
+		}
+		outputArchive = a
+		defer func() {
+			if err := a.close(); err != nil {
+				fmt.Fprintln(os.Stderr, "* Error: can't finalize archive: "+err.Error())
+			}
+		}()
+	}
+
+	// 在各模式开始前注册，收到中断信号后不再开始新的尝试，也不写入输出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	interrupted := func() bool {
+		select {
+		case sig := <-signals:
+			fmt.Printf("* Received %v, stopping\n", sig)
+			exitCode = interruptedExitCode
+		default:
+		}
+		return exitCode == interruptedExitCode
+	}
+
+	// 中断时打印提示，调用者随后直接返回而不写入输出
+	stopped := func() bool {
+		if !interrupted() {
+			return false
+		}
+		fmt.Println("* Interrupted, not saving any image")
+		return true
+	}
+
+	var cacheKeyHex string
+	if cacheDir != "" {
+		if cacheKeyHex, err = cacheKey(src, targetSidecarPath(src), metadataFrom, qtables); err != nil {
+			panic(err)
+		}
+		if entry, data, ok := loadCache(cacheDir, cacheKeyHex); ok {
+			if entry.Copied {
+				if _, err := copyFile(src, dest); err != nil {
+					panic(err)
+				}
+				fmt.Println("Cache hit: copied original image")
+				return
+			}
+			if err := save(dest, data); err != nil {
+				panic(err)
+			}
+			fmt.Printf("Cache hit: Quality = %v, SSIM = %v, Size = %.2fKB\n", entry.Quality, formatSSIM(entry.SSIM), float32(len(data))/1024)
+			chosenQualities = append(chosenQualities, entry.Quality)
+			return
+		}
+	}
+
+	// 图标等小图重新压缩收益很小且瑕疵明显，只读取头部的尺寸，不解码就直接复制
+	if skipDimensions != "" {
+		if cfg, _, err := readConfig(src); err == nil && cfg.Width <= skipW && cfg.Height <= skipH {
+			if _, err := copyFile(src, dest); err != nil {
+				panic(err)
+			}
+			fmt.Printf("Image is %vx%v, not larger than %vx%v, copied without recompressing\n", cfg.Width, cfg.Height, skipW, skipH)
+			return
+		}
+	}
+
+	// 之后的失败不能直接退出，否则归档不会写完，只留下一个无效的空文件
 	original, err := readImage(src)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "* Error: "+err.Error())
+		exitCode = 1
+		return
+	}
+	if dither && highBitDepth(original) {
+		original = ditherImage(original)
+	}
+	if autoGrayscale && looksGray(original) {
+		fmt.Println("Image has no color, encoding as grayscale")
+		original = convertToGray(original)
 	}
-	originalSize, err := getFilesize(src)
 	originalGray := convertToGray(original)
-	if err != nil {
-		panic(err)
+
+	if w, h := dim(original); borderCrop < 0 || borderCrop*2 >= w || borderCrop*2 >= h {
+		printUsageError(fmt.Sprintf("Border crop has to be between 0 and half of the smallest dimension (%vx%v).", w, h))
+		exitCode = 1
+		return
+	}
+
+	if bpp {
+		w, h := dim(original)
+		bppPixels = w * h
+	}
+	fmt.Printf("Original Size = %.2fKB%v\n", float32(originalSize)/1024, bppSuffix(originalSize))
+	if verbose {
+		if cfg, format, err := readConfig(src); err == nil {
+			fmt.Printf("Dimensions = %vx%v (%v)\n", cfg.Width, cfg.Height, format)
+		}
+		if data, err := readSource(src); err == nil {
+			if _, _, interlaced, ok := pngHeader(data); ok && interlaced {
+				fmt.Println("Source is an interlaced (Adam7) PNG")
+			}
+		}
+	}
+
+	if embedProfile {
+		if data, err := readSource(src); err == nil && hasICCProfile(data) {
+			warn("Source has its own ICC profile, not embedding sRGB")
+		} else {
+			embedSRGB = true
+		}
+	}
+
+	// 只与原图的像素数据大小比较，排除输出中不保留的元数据
+	sourceSize := originalSize
+	if payloadSize && isJpeg(src) {
+		if data, err := readSource(src); err == nil {
+			if meta := metadataSize(data); meta > 0 {
+				originalSize -= meta
+				fmt.Printf("Original Payload Size = %.2fKB (%.2fKB metadata)\n", float32(originalSize)/1024, float32(meta)/1024)
+			}
+		}
+	}
+
+	if !quiet || strict {
+		if msg := exposureWarning(originalGray, minQ); msg != "" {
+			warn(msg)
+		}
+		if msg := paletteAdvice(original); msg != "" {
+			warn(msg)
+		}
+	}
+
+	var sourceEntropy float64
+	if showEntropy {
+		sourceEntropy = grayEntropy(originalGray)
+		fmt.Printf("Entropy = %.2f bits/pixel\n", sourceEntropy)
+	}
+
+	// 搜索时编码并比较的参考图像
+	if adaptive {
+		c := complexity(originalGray)
+		target = adaptiveTarget(target, adaptiveSpread, c)
+		fmt.Printf("Adaptive target = %.6f (complexity = %.2f)\n", target, c)
+	}
+
+	reference := originalGray
+	if perChannel() {
+		reference = original
+	}
+
+	if backendQuality > 0 {
+		if err := compareBackends(os.Stdout, original, reference, backendQuality); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if ssimBenchQuality > 0 {
+		if err := compareSSIMImpls(os.Stdout, original, originalGray, ssimBenchQuality); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if fuzzCount > 0 {
+		seed := fuzzSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		if violations := fuzzQualities(os.Stdout, original, reference, fuzzCount, seed); violations > 0 {
+			fmt.Printf("* %v invariant violation(s), replay with -fuzz-seed %v\n", violations, seed)
+			exitCode = 1
+		}
+		return
+	}
+
+	if calibrateQ > 0 {
+		data, err := encodeToJPEGBytes(original, calibrateQ)
+		if err != nil {
+			panic(err)
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			panic(err)
+		}
+		suggested := suggestedTarget(index)
+		fmt.Printf("Quality %v gives SSIM = %v, Size = %.2fKB%v\n", calibrateQ, formatSSIM(index), float32(len(data))/1024, bppSuffix(int64(len(data))))
+		fmt.Printf("Suggested: %v -t %v %v %v\n", filepath.Base(os.Args[0]), suggested, src, dest)
+		return
+	}
+
+	if q, ok := pinnedQuality(pins, src); ok {
+		data, err := encodeToJPEGBytes(original, q)
+		if err != nil {
+			panic(err)
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			panic(err)
+		}
+		if stopped() {
+			return
+		}
+		if err := save(dest, data); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Pinned image:\nQuality = %v, SSIM = %v, Size = %.2fKB%v\n", q, formatSSIM(index), float32(len(data))/1024, bppSuffix(int64(len(data))))
+		chosenQualities = append(chosenQualities, q)
+		return
+	}
+
+	// 以每像素比特数为预算：先找预算内的最高质量，再在其中优先选满足目标SSIM的最小输出
+	if targetBPP > 0 {
+		w, h := dim(original)
+		budget := int64(targetBPP * float64(w*h) / 8)
+		budgetQ, ok := highestWithin(original, budget, minQ, maxQ)
+		if !ok {
+			fmt.Printf("* No quality fits in %v BPP, not saving any image\n", targetBPP)
+			return
+		}
+		q := budgetQ
+		if smallQ, _, ok := smallestMeeting(original, reference, target, minQ, budgetQ, loops); ok {
+			q = smallQ
+		}
+		data, err := encodeToJPEGBytes(original, q)
+		if err != nil {
+			panic(err)
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			panic(err)
+		}
+		if stopped() {
+			return
+		}
+		if err := save(dest, data); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Final image:\nQuality = %v, SSIM = %v, Size = %.2fKB, BPP = %.3f\n", q, formatSSIM(index), float32(len(data))/1024, float64(len(data)*8)/float64(w*h))
+		chosenQualities = append(chosenQualities, q)
+		return
+	}
+
+	// 不使用目标SSIM，直接在质量范围内最小化代价
+	if costSpec != "" {
+		q, r, err := minimizeCost(original, reference, costLambda, minQ, maxQ)
+		if err != nil {
+			panic(err)
+		}
+		if stopped() {
+			return
+		}
+		if err := save(dest, r.data); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Final image:\nQuality = %v, SSIM = %v, Size = %.2fKB%v, Cost = %.0f\n", q, formatSSIM(r.index), float32(len(r.data))/1024, bppSuffix(int64(len(r.data))), r.cost)
+		chosenQualities = append(chosenQualities, q)
+		return
+	}
+
+	// 响应式图片：对每个宽度缩小后单独搜索质量
+	if len(widths) > 0 {
+		srcWidth, _ := dim(original)
+		for _, w := range widths {
+			if w >= srcWidth {
+				warn(fmt.Sprintf("Skipping width %v, the source is only %v wide", w, srcWidth))
+				continue
+			}
+			scaled := downscale(original, w)
+			var scaledRef image.Image = scaled
+			if !perChannel() {
+				scaledRef = convertToGray(scaled)
+			}
+			q, _, ok := smallestMeeting(scaled, scaledRef, target, minQ, maxQ, loops)
+			if !ok {
+				warn(fmt.Sprintf("Nothing met the target at width %v, using the maximum quality", w))
+				q = maxQ
+			}
+			data, err := encodeToJPEGBytes(scaled, q)
+			if err != nil {
+				panic(err)
+			}
+			index, err := measure(scaledRef, data)
+			if err != nil {
+				panic(err)
+			}
+			if stopped() {
+				return
+			}
+			p := widthPath(dest, w)
+			if err := save(p, data); err != nil {
+				panic(err)
+			}
+			fmt.Printf("%v: Quality = %v, SSIM = %v, Size = %.2fKB\n", p, q, formatSSIM(index), float32(len(data))/1024)
+			chosenQualities = append(chosenQualities, q)
+		}
+		return
+	}
+
+	if len(abQualities) > 0 {
+		// 编码失败或保存失败的版本不影响其他版本的输出
+		for _, v := range encodeVariants(original, reference, abQualities) {
+			if stopped() {
+				return
+			}
+			p := qualityPath(dest, v.quality)
+			if v.err == nil {
+				v.err = save(p, v.data)
+			}
+			if v.err != nil {
+				fmt.Fprintf(os.Stderr, "* Error: %v: %v\n", p, v.err)
+				exitCode = 1
+				continue
+			}
+			fmt.Printf("%v: Quality = %v, SSIM = %v, Size = %.2fKB%v\n", p, v.quality, formatSSIM(v.index), float32(len(v.data))/1024, bppSuffix(int64(len(v.data))))
+			chosenQualities = append(chosenQualities, v.quality)
+		}
+		return
+	}
+
+	if interactiveMode {
+		if isTerminal() {
+			data, q, ok := pickQuality(original, reference, originalSize, minQ+(maxQ-minQ)/2)
+			if !ok {
+				fmt.Println("* No quality picked, not saving any image")
+				return
+			}
+			if err := save(dest, data); err != nil {
+				panic(err)
+			}
+			fmt.Printf("Saved with Quality = %v, Size = %.2fKB\n", q, float32(len(data))/1024)
+			chosenQualities = append(chosenQualities, q)
+			return
+		}
+		warn("Interactive mode needs a terminal, running the normal search")
+	}
+
+	ex := explanation{
+		isJPEG:       isJpeg(src),
+		originalSize: originalSize,
+		sourceSize:   sourceSize,
+		minQ:         minQ,
+		maxQ:         maxQ,
+		target:       target,
+		dest:         dest,
+		entropy:      sourceEntropy,
+	}
+	if explain {
+		defer func() {
+			fmt.Println()
+			fmt.Println(ex)
+		}()
+	}
+	var output []byte
+	if cacheDir != "" {
+		defer func() {
+			entry := cacheEntry{Quality: ex.quality, SSIM: ex.index}
+			switch ex.outcome {
+			case "copied", "kept":
+				entry.Copied = true
+			case "optimized", "fallback":
+			default:
+				return
+			}
+			if err := storeCache(cacheDir, cacheKeyHex, entry, output); err != nil {
+				fmt.Fprintln(os.Stderr, "* Error: can't write cache: "+err.Error())
+			}
+		}()
+	}
+	if sidecar {
+		defer func() {
+			switch ex.outcome {
+			case "optimized", "fallback", "copied", "kept":
+				if err := writeSidecar(src, ex, time.Since(started)); err != nil {
+					fmt.Fprintln(os.Stderr, "* Error: can't write sidecar: "+err.Error())
+				}
+			}
+		}()
+	}
+
+	// 对JPEG源图片，从其估算的质量开始搜索
+	var seedQ int
+	if (seed || explain) && ex.isJPEG {
+		if data, err := readSource(src); err == nil {
+			ex.sourceQ, _ = estimateJPEGQuality(data)
+		}
+	}
+	if seed && ex.sourceQ >= minQ && ex.sourceQ <= maxQ {
+		seedQ = ex.sourceQ
+		fmt.Printf("Estimated source quality = %v\n", seedQ)
 	}
-	fmt.Printf("Original Size = %.2fKB\n", float32(originalSize)/1024)
 
 	var bestSize = originalSize
 	var bestQ int
@@ -104,34 +1030,111 @@ func main() {
 	var fallbackQ int
 	var fallbackSize int64
 	var fallbackIndex float64
-	for attempt := 1; attempt <= loops; attempt++ {
-		var q = minQ + (maxQ-minQ)/2
+
+	// 判断候选结果是否满足目标SSIM以及PSNR下限
+	acceptable := func(index float64, data []byte) bool {
+		if index < target {
+			return false
+		}
+		if minPSNR > 0 {
+			p, err := measurePSNR(originalGray, data)
+			if err != nil {
+				panic("Error when comparing images: " + err.Error())
+			}
+			if p < minPSNR {
+				if verbose {
+					fmt.Printf("    PSNR = %.2fdB is below the %.2fdB floor\n", p, minPSNR)
+				}
+				return false
+			}
+		}
+		return true
+	}
+
+	lowestQ := minQ
+	tried := map[int]candidate{}
+
+	// 最低质量的输出都比原图大时，再确认最高质量，两端都更大就不必搜索
+	var skipSearch bool
+	if abortOnLarger && !interrupted() {
+		sizes := map[int]int64{}
+		for _, q := range []int{minQ, maxQ} {
+			index, data, err := compare(reference, q)
+			if err != nil {
+				panic("Error when comparing images: " + err.Error())
+			}
+			ex.attempts++
+			fmt.Printf("[+] Quality = %v, SSIM = %v, Size = %.2fKB%v\n", q, formatSSIM(index), float32(len(data))/1024, bppSuffix(int64(len(data))))
+			sizes[q] = int64(len(data))
+			tried[q] = candidate{index, int64(len(data)), acceptable(index, data)}
+			if q == minQ {
+				fallbackSize, fallbackQ, fallbackIndex = int64(len(data)), q, index
+			}
+			if int64(len(data)) < originalSize {
+				break
+			}
+		}
+		if sizes[minQ] >= originalSize && sizes[maxQ] >= originalSize {
+			fmt.Printf("* Quality %v and %v are both larger than the original, skipping the search\n", minQ, maxQ)
+			skipSearch = true
+		}
+	}
+
+	for attempt := 1; attempt <= loops && !skipSearch; attempt++ {
+		if interrupted() {
+			break
+		}
+		var q = nextQuality(minQ, maxQ)
 		if minQ == maxQ {
 			break
 		}
-		index, data, err := compare(originalGray, q)
-		if err != nil {
-			panic("Error when comparing images")
+		if attempt == 1 && seedQ > 0 {
+			q = seedQ
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			warn(fmt.Sprintf("Time budget of %v exhausted after %v attempts", timeBudget, attempt-1))
+			break
 		}
-		newSize := int64(len(data))
-		fmt.Printf("[%v] Quality = %v, SSIM = %.5f, Size = %.2fKB\n", attempt, q, index, float32(newSize)/1024)
+		// -abort-on-larger 已经编码过的质量不再重复编码
+		c, ok := tried[q]
+		if !ok {
+			index, data, err := compare(reference, q)
+			if err != nil {
+				panic("Error when comparing images: " + err.Error())
+			}
+			ex.attempts++
+			c = candidate{index, int64(len(data)), acceptable(index, data)}
+			tried[q] = c
+		}
+		index, newSize, meets := c.index, c.size, c.meets
+		fmt.Printf("[%v] Quality = %v, SSIM = %v, Size = %.2fKB%v\n", attempt, q, formatSSIM(index), float32(newSize)/1024, bppSuffix(newSize))
 
+		prevMin, prevMax := minQ, maxQ
+		var reason string
 		if newSize >= originalSize {
-			if index < target {
+			if !meets {
 				attempt = loops
+				reason = "is not smaller than the original and below target, stopping"
 			} else {
 				maxQ = int(math.Max(float64(q-1), float64(minQ)))
+				reason = "is not smaller than the original, lowering max"
 			}
 		} else {
-			if index < target {
+			if !meets {
 				minQ = int(math.Min(float64(q+1), float64(maxQ)))
+				reason = "is below target, raising min"
 			} else if index > target {
 				maxQ = int(math.Max(float64(q-1), float64(minQ)))
+				reason = "is above target, lowering max to look for a smaller file"
 			} else {
 				attempt = loops
+				reason = "is exactly on target, stopping"
 			}
 		}
-		if newSize < bestSize && index >= target {
+		if verbose {
+			fmt.Printf("    q%v %v (range %v-%v -> %v-%v)\n", q, reason, prevMin, prevMax, minQ, maxQ)
+		}
+		if newSize < bestSize && meets {
 			bestSize = newSize
 			bestQ = q
 			bestIndex = index
@@ -151,17 +1154,116 @@ func main() {
 		}
 	}
 
+	// 继续降低质量，直到低于目标SSIM，寻找仍满足目标的最小文件
+	if minimizeSize && bestQ > 0 {
+		for q := bestQ - 1; q >= lowestQ && !interrupted(); q-- {
+			c, ok := tried[q]
+			if !ok {
+				index, data, err := compare(reference, q)
+				if err != nil {
+					panic("Error when comparing images: " + err.Error())
+				}
+				ex.attempts++
+				fmt.Printf("[-] Quality = %v, SSIM = %v, Size = %.2fKB%v\n", q, formatSSIM(index), float32(len(data))/1024, bppSuffix(int64(len(data))))
+				c = candidate{index, int64(len(data)), acceptable(index, data)}
+				tried[q] = c
+			}
+			if !c.meets {
+				break
+			}
+			if c.size < bestSize {
+				bestSize = c.size
+				bestQ = q
+				bestIndex = c.index
+			}
+		}
+	}
+
+	// 固定亮度质量，单独降低色度质量
+	if separateChroma && bestQ > 0 && !interrupted() {
+		chromaQ := searchChroma(original, bestQ, lowestQ, chromaTarget, loops)
+		if chromaQ < bestQ {
+			prevEncoder := customEncoder
+			var options jpegenc.Options
+			if prevEncoder != nil {
+				options = *prevEncoder
+			}
+			options.ChromaQuality = chromaQ
+			customEncoder = &options
+			// 之后的取舍、报告和检查都要基于降低色度质量后的输出
+			data, err := encodeToJPEGBytes(original, bestQ)
+			if err != nil {
+				panic(err)
+			}
+			index, err := measure(reference, data)
+			if err != nil {
+				panic("Error when comparing images: " + err.Error())
+			}
+			// 色度量化也会改变测得的SSIM，不再满足目标时恢复原来的色度质量和结果
+			if acceptable(index, data) {
+				bestSize, bestIndex = int64(len(data)), index
+			} else {
+				customEncoder = prevEncoder
+				fmt.Printf("* Chroma Quality %v misses the target (SSIM = %v), keeping the luma quality for chroma\n", chromaQ, formatSSIM(index))
+				chromaQ = bestQ
+			}
+		}
+		fmt.Printf("Chroma Quality = %v\n", chromaQ)
+	}
+
+	if interrupted() {
+		if bestQ > 0 {
+			fmt.Printf("Best so far: Quality = %v, SSIM = %v, Size = %.2fKB%v\n", bestQ, formatSSIM(bestIndex), float32(bestSize)/1024, bppSuffix(bestSize))
+		}
+		fmt.Println("* Interrupted, not saving any image")
+		ex.outcome = "interrupted"
+		return
+	}
+
+	// 原图的SSIM为1，按代价函数决定是否值得换成压缩后的图片
+	if keepBetter && bestSize < originalSize && isJpeg(src) {
+		if cost := tradeoffCost(bestSize, bestIndex, originalSize, qualityWeight); cost >= float64(originalSize) {
+			fmt.Printf("* Output cost %.0f is not below the original's %v, copying original image\n", cost, originalSize)
+			if _, err := copyFile(src, dest); err != nil {
+				panic(err)
+			}
+			ex.outcome, ex.quality = "kept", bestQ
+			return
+		}
+	}
+
 	if bestSize < originalSize {
 		data, err := encodeToJPEGBytes(original, bestQ)
 		if err != nil {
 			panic(err)
 		}
-		save(dest, data)
-		fmt.Printf("Final image:\nQuality = %v, SSIM = %.5f, Size = %.2fKB\n", bestQ, bestIndex, float32(bestSize)/1024)
+		if err := save(dest, data); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Final image:\nQuality = %v, SSIM = %v, Size = %.2fKB%v\n", bestQ, formatSSIM(bestIndex), float32(bestSize)/1024, bppSuffix(bestSize))
+		if phash {
+			printHashDrift(originalGray, data)
+		}
+		if reportColor {
+			ex.colorIndex = printColorSSIM(original, data)
+		}
+		if restartInterval > 0 {
+			printRestartCost(original, bestQ, data)
+		}
+		if checkDeterminism && !encodesDeterministically(original, bestQ, data) {
+			exitCode = 1
+		}
 		fmt.Printf("%.1f%% of original, saved %.2fKB", float32(bestSize)/float32(originalSize)*100, float32(originalSize-bestSize)/1024)
+		if verifySize {
+			checkWrittenSize(src, dest)
+		}
+		ex.outcome, ex.quality, ex.index, ex.size = "optimized", bestQ, bestIndex, int64(len(data))
+		chosenQualities = append(chosenQualities, bestQ)
+		output = data
 	} else {
 		if noCopy {
 			fmt.Println("* Can't find any match, not saving any image")
+			ex.outcome = "none"
 			return
 		}
 		if isJpeg(src) {
@@ -170,15 +1272,107 @@ func main() {
 			if err != nil {
 				panic(err)
 			}
+			ex.outcome = "copied"
 		} else {
-			fmt.Println("* Can't find any match, falling back to closest match")
-			fmt.Printf("Final image:\nQuality = %v, SSIM = %.5f, Size = %.2fKB\n", fallbackQ, fallbackIndex, float32(fallbackSize)/1024)
-			fmt.Printf("%.1f%% of original, saved %.2fKB", float32(fallbackSize)/float32(originalSize)*100, float32(originalSize-fallbackSize)/1024)
 			data, err := encodeToJPEGBytes(original, fallbackQ)
 			if err != nil {
 				panic(err)
 			}
-			save(dest, data)
+			if noFallbackEnlarge && int64(len(data)) > originalSize {
+				warn("Can't find any match and the closest match is larger, copying oringal image")
+				if _, err := copyFile(src, dest); err != nil {
+					panic(err)
+				}
+				ex.outcome = "copied"
+				return
+			}
+			warn("Can't find any match, falling back to closest match")
+			fmt.Printf("Final image:\nQuality = %v, SSIM = %v, Size = %.2fKB%v\n", fallbackQ, formatSSIM(fallbackIndex), float32(fallbackSize)/1024, bppSuffix(fallbackSize))
+			if phash {
+				printHashDrift(originalGray, data)
+			}
+			if reportColor {
+				ex.colorIndex = printColorSSIM(original, data)
+			}
+			if restartInterval > 0 {
+				printRestartCost(original, fallbackQ, data)
+			}
+			if checkDeterminism && !encodesDeterministically(original, fallbackQ, data) {
+				exitCode = 1
+			}
+			fmt.Printf("%.1f%% of original, saved %.2fKB", float32(fallbackSize)/float32(originalSize)*100, float32(originalSize-fallbackSize)/1024)
+			if err := save(dest, data); err != nil {
+				panic(err)
+			}
+			if verifySize {
+				checkWrittenSize(src, dest)
+			}
+			ex.outcome, ex.quality, ex.index, ex.size = "fallback", fallbackQ, fallbackIndex, int64(len(data))
+			chosenQualities = append(chosenQualities, fallbackQ)
+			output = data
 		}
 	}
 }
+
+// 检查写入的输出大小，比原图大时改为复制原图
+func checkWrittenSize(src, dest string) {
+	replaced, err := ensureNotLarger(src, dest)
+	if err != nil {
+		panic(err)
+	}
+	if replaced {
+		fmt.Println()
+		warn("Output is larger than the original on disk, copied the original image instead")
+	}
+}
+
+// 打印重启标记使输出增加的大小
+func printRestartCost(original image.Image, quality int, data []byte) {
+	interval := customEncoder.RestartInterval
+	customEncoder.RestartInterval = 0
+	plain, err := encodeToJPEGBytes(original, quality)
+	customEncoder.RestartInterval = interval
+	if err != nil {
+		panic(err)
+	}
+	delta := len(data) - len(plain)
+	fmt.Printf("Restart markers every %v MCUs add %.2fKB (%.1f%%)\n", interval, float32(delta)/1024, float32(delta)/float32(len(plain))*100)
+}
+
+// 打印原图与输出图像的感知哈希漂移
+func printHashDrift(originalGray image.Image, data []byte) {
+	before, after, distance, err := hashDrift(originalGray, data)
+	if err != nil {
+		warn("Can't compute perceptual hash: " + err.Error())
+		return
+	}
+	fmt.Printf("pHash = %016x -> %016x, Distance = %v/64\n", before, after, distance)
+}
+
+// 打印输出的彩色SSIM，返回三个通道的平均值
+func printColorSSIM(original image.Image, data []byte) float64 {
+	mean, channels, err := colorSSIM(original, data)
+	if err != nil {
+		warn("Can't compute color SSIM: " + err.Error())
+		return 0
+	}
+	names := "Y, Cb, Cr"
+	if ssimSpace == "lab" {
+		names = "L*, a*, b*"
+	}
+	fmt.Printf("Color SSIM = %v (%v = %v, %v, %v)\n", formatSSIM(mean), names, formatSSIM(channels[0]), formatSSIM(channels[1]), formatSSIM(channels[2]))
+	return mean
+}
+
+// 再次以相同质量编码，检查结果与data是否逐字节相同
+func encodesDeterministically(original image.Image, quality int, data []byte) bool {
+	again, err := encodeToJPEGBytes(original, quality)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(data, again) {
+		fmt.Fprintf(os.Stderr, "* Encoding at quality %v is not deterministic (%v vs %v bytes)\n", quality, len(data), len(again))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// 调色板颜色数不超过该值时提示JPEG可能不适合
+const fewColors = 64
+
+// 先把调色板转换为灰阶，再按索引查表，避免逐像素转换颜色
+func palettedToGray(p *image.Paletted) *image.Gray {
+	lut := make([]uint8, len(p.Palette))
+	for i, c := range p.Palette {
+		lut[i] = color.GrayModel.Convert(c).(color.Gray).Y
+	}
+
+	b := p.Bounds()
+	gray := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := p.Pix[(y-b.Min.Y)*p.Stride:]
+		dst := gray.Pix[(y-b.Min.Y)*gray.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			if int(src[x]) < len(lut) {
+				dst[x] = lut[src[x]]
+			}
+		}
+	}
+	return gray
+}
+
+// 对颜色很少的调色板图像返回提示，否则返回空字符串
+func paletteAdvice(img image.Image) string {
+	p, ok := img.(*image.Paletted)
+	if !ok || len(p.Palette) > fewColors {
+		return ""
+	}
+	return "Source is an indexed image with only a few colors; JPEG may be a poor fit, consider keeping a lossless format such as PNG"
+}
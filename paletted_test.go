@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写出一个4色的调色板图像，返回文件路径
+func writePaletted(t *testing.T, name string) string {
+	t.Helper()
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 255},
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 128, 255, 255},
+		color.RGBA{250, 250, 250, 255},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 16, 8), palette)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetColorIndex(x, y, uint8((x/4+y)%len(palette)))
+		}
+	}
+
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".gif" {
+		err = gif.Encode(f, img, nil)
+	} else {
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPalettedSources(t *testing.T) {
+	for _, name := range []string{"indexed.png", "indexed.gif"} {
+		t.Run(name, func(t *testing.T) {
+			img, err := readImage(writePaletted(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, ok := img.(*image.Paletted)
+			if !ok {
+				t.Fatalf("decoded %T, want *image.Paletted", img)
+			}
+
+			gray := convertToGray(p)
+			b := p.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					want := color.GrayModel.Convert(p.At(x, y))
+					if got := gray.At(x, y); got != want {
+						t.Fatalf("gray at (%d,%d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+
+			if paletteAdvice(p) == "" {
+				t.Error("no advice for a 4-color image")
+			}
+		})
+	}
+}
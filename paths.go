@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// 输出路径试图跳出输出根目录时的错误
+var errPathEscape = errors.New("path escapes the output root")
+
+// 将相对路径清理为以/分隔、不以/开头的形式，含有跳出根目录的..时返回错误
+func cleanRelative(p string) (string, error) {
+	p = strings.TrimLeft(filepath.ToSlash(filepath.Clean(filepath.ToSlash(p))), "/")
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", errPathEscape
+	}
+	return p, nil
+}
+
+// 返回root下的rel路径，rel试图跳出root时返回错误
+func confinedPath(root, rel string) (string, error) {
+	clean, err := cleanRelative(rel)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfinedPath(t *testing.T) {
+	root := filepath.Join("out", "root")
+	tests := []struct {
+		rel  string
+		want string
+		err  error
+	}{
+		{"a.jpg", "a.jpg", nil},
+		{"sub/dir/a.jpg", "sub/dir/a.jpg", nil},
+		{"./sub//a.jpg", "sub/a.jpg", nil},
+		{"sub/../a.jpg", "a.jpg", nil},
+		{"/etc/passwd", "etc/passwd", nil},
+		{"//double/slash.jpg", "double/slash.jpg", nil},
+		{"..", "", errPathEscape},
+		{"../a.jpg", "", errPathEscape},
+		{"sub/../../a.jpg", "", errPathEscape},
+		{"sub/./../../../etc/passwd", "", errPathEscape},
+		{"/../a.jpg", "a.jpg", nil},
+		{"..a.jpg", "..a.jpg", nil},
+		{"a/..b/c.jpg", "a/..b/c.jpg", nil},
+	}
+	for _, tt := range tests {
+		clean, err := cleanRelative(tt.rel)
+		if !errors.Is(err, tt.err) || clean != tt.want {
+			t.Errorf("cleanRelative(%q) = %q, %v, want %q, %v", tt.rel, clean, err, tt.want, tt.err)
+		}
+
+		p, err := confinedPath(root, tt.rel)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("confinedPath(%q) err = %v, want %v", tt.rel, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("confinedPath(%q): %v", tt.rel, err)
+		}
+		if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			t.Errorf("confinedPath(%q) = %q, outside %q", tt.rel, p, root)
+		}
+	}
+}
+
+func TestArchiveRejectsEscapingNames(t *testing.T) {
+	a, err := createArchive(filepath.Join(t.TempDir(), "out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	if err := a.add("../../escape.jpg", []byte("x")); !errors.Is(err, errPathEscape) {
+		t.Errorf("add err = %v, want %v", err, errPathEscape)
+	}
+
+	dir := t.TempDir()
+	_, stderr, code := runMain(t, "-archive", filepath.Join(dir, "out.zip"), filepath.Join("testdata", "photo.png"), "../escape.jpg")
+	if code != 1 || !strings.Contains(stderr, "outside of the archive root") {
+		t.Errorf("exit %d, stderr %q", code, stderr)
+	}
+}
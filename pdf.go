@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 找出PDF中以DCTDecode编码、可直接作为JPEG解码的图像流
+func pdfJPEGStreams(pdf []byte) [][]byte {
+	var streams [][]byte
+	for rest := pdf; ; {
+		i := bytes.Index(rest, []byte("/DCTDecode"))
+		if i < 0 {
+			return streams
+		}
+		rest = rest[i:]
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			return streams
+		}
+		rest = rest[start+len("stream"):]
+		// stream关键字之后是CRLF或LF
+		rest = bytes.TrimPrefix(rest, []byte("\r"))
+		rest = bytes.TrimPrefix(rest, []byte("\n"))
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			return streams
+		}
+		data := bytes.TrimRight(rest[:end], "\r\n")
+		// 同时使用其他过滤器（如FlateDecode）的流不是原始JPEG数据
+		if bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+			streams = append(streams, data)
+		}
+		rest = rest[end:]
+	}
+}
+
+// 只读地报告重新压缩PDF中各个JPEG图像可节省的大小，不修改PDF
+func reportPDF(w io.Writer, src string, target float64, minQ, maxQ, loops int) error {
+	pdf, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	streams := pdfJPEGStreams(pdf)
+	if len(streams) == 0 {
+		fmt.Fprintln(w, "No embedded JPEG images found")
+		return nil
+	}
+
+	var total, saved int64
+	for i, data := range streams {
+		size := int64(len(data))
+		total += size
+		img, err := decodeImage(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(w, "[%v] %.2fKB, can't decode: %v\n", i+1, float32(size)/1024, err)
+			continue
+		}
+		q, newSize, ok := smallestMeeting(img, convertToGray(img), target, minQ, maxQ, loops)
+		if !ok || newSize >= size {
+			fmt.Fprintf(w, "[%v] %vx%v, %.2fKB, no smaller match\n", i+1, img.Bounds().Dx(), img.Bounds().Dy(), float32(size)/1024)
+			continue
+		}
+		saved += size - newSize
+		fmt.Fprintf(w, "[%v] %vx%v, %.2fKB -> Quality = %v, %.2fKB\n", i+1, img.Bounds().Dx(), img.Bounds().Dy(),
+			float32(size)/1024, q, float32(newSize)/1024)
+	}
+	fmt.Fprintf(w, "%v JPEG images, %.2fKB in total, could save %.2fKB of a %.2fKB PDF\n",
+		len(streams), float32(total)/1024, float32(saved)/1024, float32(len(pdf))/1024)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math/bits"
+)
+
+// 计算灰阶图像的差异哈希(dHash)：缩小为9x8后逐行比较相邻像素的亮度
+func dHash(img image.Image) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var cells [8][9]float64
+	for row := 0; row < 8; row++ {
+		y0 := b.Min.Y + row*h/8
+		y1 := b.Min.Y + (row+1)*h/8
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for col := 0; col < 9; col++ {
+			x0 := b.Min.X + col*w/9
+			x1 := b.Min.X + (col+1)*w/9
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			cells[row][col] = areaMean(img, image.Rect(x0, y0, x1, y1).Intersect(b))
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash <<= 1
+			if cells[row][col] < cells[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// 计算图像指定区域内像素值的平均值
+func areaMean(img image.Image, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	sum := 0.0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += getPixVal(img.At(x, y))
+		}
+	}
+	return sum / float64(r.Dx()*r.Dy())
+}
+
+// 返回原图与压缩后图像的dHash以及两者的汉明距离
+func hashDrift(originalGray image.Image, raw []byte) (before, after uint64, distance int, err error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	before = dHash(originalGray)
+	after = dHash(convertToGray(decoded))
+	distance = bits.OnesCount64(before ^ after)
+	return
+}
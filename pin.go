@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 解析以逗号分隔的 文件名=质量 列表
+func parsePins(s string) (map[string]int, error) {
+	pins := map[string]int{}
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("'%s' is not in the form file=quality", part)
+		}
+		q, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", value)
+		}
+		if q < 1 || q > 100 {
+			return nil, errors.New("quality has to be between 1 and 100")
+		}
+		pins[name] = q
+	}
+	return pins, nil
+}
+
+// 按完整路径或文件名查找固定的质量
+func pinnedQuality(pins map[string]int, src string) (int, bool) {
+	if q, ok := pins[src]; ok {
+		return q, true
+	}
+	q, ok := pins[filepath.Base(src)]
+	return q, ok
+}
@@ -0,0 +1,28 @@
+package main
+
+import "image"
+
+// 与目标比较的指标：ssim 或 pixel-diff（灰阶差小于阈值的像素比例）
+var metric = "ssim"
+
+// pixel-diff 中灰阶差小于该值的像素视为未变化，范围1-255
+var pixelThreshold = 5
+
+// 返回两个尺寸相同的灰阶图像中，灰阶差小于threshold的像素所占的比例
+func pixelsWithin(x, y image.Image, threshold int) float64 {
+	minX, minY := x.Bounds().Min, y.Bounds().Min
+	w, h := dim(x)
+	if w*h == 0 {
+		return 0
+	}
+	within := 0
+	for px := 0; px < w; px++ {
+		for py := 0; py < h; py++ {
+			d := getPixVal(x.At(minX.X+px, minX.Y+py)) - getPixVal(y.At(minY.X+px, minY.Y+py))
+			if d < float64(threshold) && d > -float64(threshold) {
+				within++
+			}
+		}
+	}
+	return float64(within) / float64(w*h)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// PNG文件签名
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// 直接从IHDR读取PNG的尺寸和是否为Adam7隔行扫描，不依赖已注册的解码器
+func pngHeader(head []byte) (w, h int, interlaced, ok bool) {
+	// 签名(8) + 长度(4) + "IHDR"(4) + 宽高(8) + 位深、颜色类型、压缩、过滤、隔行(5)
+	if len(head) < 29 || !bytes.HasPrefix(head, pngSignature) || string(head[12:16]) != "IHDR" {
+		return 0, 0, false, false
+	}
+	w = int(binary.BigEndian.Uint32(head[16:20]))
+	h = int(binary.BigEndian.Uint32(head[20:24]))
+	return w, h, head[28] == 1, true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestInterlacedPNG(t *testing.T) {
+	data, err := os.ReadFile("testdata/interlaced.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h, interlaced, ok := pngHeader(data)
+	if !ok || w != 16 || h != 16 || !interlaced {
+		t.Fatalf("pngHeader = %d, %d, %v, %v, want 16, 16, true, true", w, h, interlaced, ok)
+	}
+
+	img, err := readImage("testdata/interlaced.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.Gray", img)
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if got, want := gray.GrayAt(x, y).Y, uint8((x*16+y*5)%256); got != want {
+				t.Fatalf("pixel (%d,%d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestInterlacedPNGPixelCap(t *testing.T) {
+	defer func(limit int64) { maxPixels = limit }(maxPixels)
+	maxPixels = 255
+
+	_, err := readImage("testdata/interlaced.png")
+	var tooLarge tooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.width != 16 || tooLarge.height != 16 {
+		t.Fatalf("err = %v, want a tooLargeError for 16x16", err)
+	}
+}
+
+func TestInterlacedPNGHeaderOnlyBomb(t *testing.T) {
+	data, err := os.ReadFile("testdata/interlaced.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 把IHDR中的尺寸改成10万x10万并截掉像素数据，只有在解码前按头部检查才会报告尺寸
+	bomb := append([]byte(nil), data[:33]...)
+	copy(bomb[16:24], []byte{0, 1, 0x86, 0xa0, 0, 1, 0x86, 0xa0})
+	if _, err := decodeImage(bytes.NewReader(bomb)); !errors.As(err, new(tooLargeError)) {
+		t.Fatalf("err = %v, want a tooLargeError", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestPrintQualityEveryMode(t *testing.T) {
+	png := filepath.Join("testdata", "photo.png")
+	jpg := writeTaggedJPEG(t, t.TempDir(), 100)
+	tests := []struct {
+		name, src string
+		args      []string
+		want      string
+	}{
+		{"search", png, []string{"-t", "0.99"}, `^\d+\n$`},
+		{"pin", png, []string{"-pin", "photo.png=80"}, `^80\n$`},
+		{"target-bpp", png, []string{"-target-bpp", "1.5"}, `^\d+\n$`},
+		{"cost", png, []string{"-cost", "balanced"}, `^\d+\n$`},
+		{"widths", png, []string{"-t", "0.99", "-widths", "48,64"}, `^\d+\n\d+\n$`},
+		{"ab", png, []string{"-ab", "70,80"}, `^70\n80\n$`},
+		// 复制原图时没有选择质量，什么都不打印
+		{"copied", jpg, []string{"-t", "0.99999"}, `^$`},
+	}
+	for _, tt := range tests {
+		dest := filepath.Join(t.TempDir(), "out.jpg")
+		stdout, stderr, code := runMain(t, append(append([]string{"-print-quality"}, tt.args...), tt.src, dest)...)
+		if code != 0 {
+			t.Fatalf("%s: exit %d: %s", tt.name, code, stderr)
+		}
+		if !regexp.MustCompile(tt.want).MatchString(stdout) {
+			t.Errorf("%s: stdout %q, want %s", tt.name, stdout, tt.want)
+		}
+	}
+}
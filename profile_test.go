@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilesWrittenOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	bad := writeSource(t, "bad.jpg", []byte("not an image"))
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"undecodable", []string{bad, filepath.Join(dir, "out.jpg")}},
+		{"bad archive", []string{"-archive", filepath.Join(dir, "no", "such", "a.zip"), "-mkdir", bad, "out.jpg"}},
+	}
+	for _, tt := range tests {
+		cpu := filepath.Join(dir, "cpu.out")
+		mem := filepath.Join(dir, "mem.out")
+		os.Remove(cpu)
+		os.Remove(mem)
+		args := append([]string{"-cpuprofile", cpu, "-memprofile", mem}, tt.args...)
+		if _, _, code := runMain(t, args...); code != 1 {
+			t.Errorf("%s: exit %d, want 1", tt.name, code)
+		}
+		for _, p := range []string{cpu, mem} {
+			if fi, err := os.Stat(p); err != nil || fi.Size() == 0 {
+				t.Errorf("%s: %s is missing or empty", tt.name, filepath.Base(p))
+			}
+		}
+	}
+}
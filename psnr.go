@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// 计算两个灰阶图像的峰值信噪比(PSNR)，单位为dB，图像相同时为+Inf
+func psnr(x, y image.Image) float64 {
+	minX, minY := x.Bounds().Min, y.Bounds().Min
+	w, h := dim(x)
+	sum := 0.0
+	for px := 0; px < w; px++ {
+		for py := 0; py < h; py++ {
+			d := getPixVal(x.At(minX.X+px, minX.Y+py)) - getPixVal(y.At(minY.X+px, minY.Y+py))
+			sum += d * d
+		}
+	}
+	mse := sum / float64(w*h)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(L*L/mse)
+}
+
+// 解码压缩后的图片，返回其与原图灰阶的PSNR
+func measurePSNR(originalGray image.Image, raw []byte) (float64, error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return 0, err
+	}
+	return psnr(originalGray, convertToGray(decoded)), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// 自定义量化表文件格式，luma和chroma均为8x8的自然顺序（按行）表
+type quantTablesFile struct {
+	Luma   [][]int `json:"luma"`
+	Chroma [][]int `json:"chroma"`
+}
+
+// 读取并校验自定义量化表
+func loadQuantTables(path string) (*[2][64]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f quantTablesFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	var tables [2][64]byte
+	for i, t := range [][][]int{f.Luma, f.Chroma} {
+		name := [...]string{"luma", "chroma"}[i]
+		if len(t) != 8 {
+			return nil, errors.New(name + " table must have 8 rows")
+		}
+		for y, row := range t {
+			if len(row) != 8 {
+				return nil, fmt.Errorf("%s table row %d must have 8 values", name, y+1)
+			}
+			for x, v := range row {
+				if v < 1 || v > 255 {
+					return nil, fmt.Errorf("%s table value at row %d, column %d has to be between 1 and 255", name, y+1, x+1)
+				}
+				tables[i][y*8+x] = byte(v)
+			}
+		}
+	}
+	return &tables, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 把data写入临时目录中的文件并返回其路径
+func writeSource(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadImageEmptyAndTruncated(t *testing.T) {
+	jpg, err := encodeToJPEGBytes(loadFixture(t, "photo"), 85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty.jpg", nil, errEmptyImage},
+		{"chopped.jpg", jpg[:len(jpg)*2/3], errTruncatedImage},
+		{"header.jpg", jpg[:len(jpg)/8], errTruncatedImage},
+		{"notes.jpg", []byte("just some text, not an image\n"), errNotImage},
+	}
+	for _, tt := range tests {
+		src := writeSource(t, tt.name, tt.data)
+		if _, err := readImage(src); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+
+		// 命令行报告错误并以1退出，而不是panic
+		_, stderr, code := runMain(t, src, filepath.Join(t.TempDir(), "out.jpg"))
+		if code != 1 || !strings.Contains(stderr, "* Error: ") || !strings.Contains(stderr, tt.want.Error()) {
+			t.Errorf("%s: exit %d, stderr %q", tt.name, code, stderr)
+		}
+		if strings.Contains(stderr, "panic") {
+			t.Errorf("%s: panicked: %s", tt.name, stderr)
+		}
+	}
+}
+
+func TestReadImageMissingDecoder(t *testing.T) {
+	tests := []struct {
+		name, contentType, pkg string
+		data                   []byte
+	}{
+		{"image.webp", "image/webp", "golang.org/x/image/webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")},
+		{"image.bmp", "image/bmp", "golang.org/x/image/bmp", append([]byte("BM"), make([]byte, 60)...)},
+	}
+	for _, tt := range tests {
+		_, err := readImage(writeSource(t, tt.name, tt.data))
+		var missing missingDecoderError
+		if !errors.As(err, &missing) {
+			t.Fatalf("%s: err = %v, want missingDecoderError", tt.name, err)
+		}
+		if missing.contentType != tt.contentType {
+			t.Errorf("%s: detected %q, want %q", tt.name, missing.contentType, tt.contentType)
+		}
+		if !strings.Contains(err.Error(), tt.pkg) {
+			t.Errorf("%s: message %q does not name %s", tt.name, err, tt.pkg)
+		}
+	}
+}
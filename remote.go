@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 远程图片的下载参数，由命令行参数设置
+var (
+	fetchTimeout       = 30 * time.Second
+	fetchLimit   int64 = 50 << 20
+)
+
+// 已下载的远程图片，避免重复下载
+var fetched = map[string][]byte{}
+
+// 判断路径是否为HTTP(S)地址
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// 下载远程图片到内存，超过大小限制时返回错误
+func fetchURL(url string) ([]byte, error) {
+	if data, ok := fetched[url]; ok {
+		return data, nil
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength > fetchLimit {
+		return nil, fmt.Errorf("fetching %s: size %d exceeds the limit of %d bytes", url, resp.ContentLength, fetchLimit)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fetchLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > fetchLimit {
+		return nil, fmt.Errorf("fetching %s: exceeds the limit of %d bytes", url, fetchLimit)
+	}
+	fetched[url] = data
+	return data, nil
+}
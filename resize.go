@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 缩小图像时使用的重采样滤镜：box（面积平均）、nearest、bilinear、bicubic 或 lanczos
+var resizeFilter = "bilinear"
+
+// 可分离的重采样核，support是原尺寸下核的半径
+type resampleKernel struct {
+	support float64
+	at      func(t float64) float64
+}
+
+// box 和 nearest 以外的重采样核
+var resampleKernels = map[string]resampleKernel{
+	"bilinear": {1, func(t float64) float64 {
+		return 1 - math.Abs(t)
+	}},
+	// Catmull-Rom
+	"bicubic": {2, func(t float64) float64 {
+		t = math.Abs(t)
+		if t < 1 {
+			return (3*t*t*t - 5*t*t + 2) / 2
+		}
+		return (-t*t*t + 5*t*t - 8*t + 4) / 2
+	}},
+	// Lanczos3
+	"lanczos": {3, func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		x := math.Pi * t
+		return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+	}},
+}
+
+// 判断是否是支持的重采样滤镜
+func validResizeFilter(name string) bool {
+	_, ok := resampleKernels[name]
+	return ok || name == "box" || name == "nearest"
+}
+
+// 用resizeFilter将图像缩小到指定宽度，保持宽高比
+func downscale(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	switch resizeFilter {
+	case "box":
+		return boxDownscale(img, width, height)
+	case "nearest":
+		return nearestDownscale(img, width, height)
+	}
+	return resample(img, width, height, resampleKernels[resizeFilter])
+}
+
+// 按面积平均缩小图像
+func boxDownscale(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*sh/height, b.Min.Y+(y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*sw/width, b.Min.X+(x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return out
+}
+
+// 取每个输出像素中心对应的源像素
+func nearestDownscale(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + (2*y+1)*sh/(2*height)
+		for x := 0; x < width; x++ {
+			sx := b.Min.X + (2*x+1)*sw/(2*width)
+			out.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return out
+}
+
+// 一个输出像素在源图一行或一列上的采样位置和权重
+type resampleTaps struct {
+	first   int
+	weights []float64
+}
+
+// 计算从srcLen个像素缩放到dstLen个像素时每个输出像素的采样权重。
+// 缩小时核按比例放大，使其覆盖所有参与的源像素
+func resampleWeights(srcLen, dstLen int, k resampleKernel) []resampleTaps {
+	scale := float64(srcLen) / float64(dstLen)
+	if scale < 1 {
+		scale = 1
+	}
+	radius := k.support * scale
+	taps := make([]resampleTaps, dstLen)
+	for i := range taps {
+		center := (float64(i)+0.5)*float64(srcLen)/float64(dstLen) - 0.5
+		first := int(math.Ceil(center - radius))
+		last := int(math.Floor(center + radius))
+		if first < 0 {
+			first = 0
+		}
+		if last > srcLen-1 {
+			last = srcLen - 1
+		}
+		weights := make([]float64, last-first+1)
+		sum := 0.0
+		for j := range weights {
+			weights[j] = k.at((float64(first+j) - center) / scale)
+			sum += weights[j]
+		}
+		for j := range weights {
+			weights[j] /= sum
+		}
+		taps[i] = resampleTaps{first, weights}
+	}
+	return taps
+}
+
+// 用可分离的重采样核先横向、再纵向缩放图像
+func resample(img image.Image, width, height int, k resampleKernel) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, sw, sh))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	xTaps := resampleWeights(sw, width, k)
+	rows := make([]float64, sh*width*4)
+	for y := 0; y < sh; y++ {
+		line := src.Pix[y*src.Stride:]
+		for x, t := range xTaps {
+			acc := rows[(y*width+x)*4:][:4]
+			for j, w := range t.weights {
+				p := line[(t.first+j)*4:][:4]
+				for c := range acc {
+					acc[c] += w * float64(p[c])
+				}
+			}
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, t := range resampleWeights(sh, height, k) {
+		for x := 0; x < width; x++ {
+			var acc [4]float64
+			for j, w := range t.weights {
+				p := rows[((t.first+j)*width+x)*4:][:4]
+				for c := range acc {
+					acc[c] += w * p[c]
+				}
+			}
+			// 锐化的核会过冲，颜色值还不能超过预乘的alpha
+			a := clampByte(acc[3])
+			o := out.Pix[y*out.Stride+x*4:][:4]
+			for c := 0; c < 3; c++ {
+				if v := clampByte(acc[c]); v < a {
+					o[c] = v
+				} else {
+					o[c] = a
+				}
+			}
+			o[3] = a
+		}
+	}
+	return out
+}
+
+// 返回在扩展名前加上宽度后缀的输出路径，如 out.jpg -> out.w480.jpg
+func widthPath(dest string, width int) string {
+	ext := filepath.Ext(dest)
+	return fmt.Sprintf("%s.w%d%s", strings.TrimSuffix(dest, ext), width, ext)
+}
+
+// 解析以逗号分隔的宽度列表
+func parseWidths(s string) ([]int, error) {
+	var widths []int
+	for _, part := range strings.Split(s, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", part)
+		}
+		if w <= 0 {
+			return nil, errors.New("widths have to be more than 0")
+		}
+		widths = append(widths, w)
+	}
+	return widths, nil
+}
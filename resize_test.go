@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWidthsMeasureAgainstDownscaledReference(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out.jpg")
+	stdout, stderr, code := runMain(t, "-widths", "48,64", filepath.Join("testdata", "photo.png"), dest)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	original := loadFixture(t, "photo")
+	for _, w := range []int{48, 64} {
+		p := widthPath(dest, w)
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := measure(convertToGray(downscale(original, w)), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "SSIM = " + formatSSIM(index) + ","; !lineContains(stdout, p+": ", want) {
+			t.Errorf("width %v: expected %q in the line for %v:\n%s", w, want, p, stdout)
+		}
+	}
+}
+
+// 判断以prefix开头的行是否包含s
+func lineContains(out, prefix, s string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, prefix) && strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWidthsDefaultFilterIsBilinear(t *testing.T) {
+	src := filepath.Join("testdata", "photo.png")
+	var outputs [2][]byte
+	for i, args := range [][]string{nil, {"-resize-filter", "bilinear"}} {
+		dest := filepath.Join(t.TempDir(), "out.jpg")
+		args = append(append([]string{"-widths", "48"}, args...), src, dest)
+		if _, stderr, code := runMain(t, args...); code != 0 {
+			t.Fatalf("exit %d: %s", code, stderr)
+		}
+		data, err := os.ReadFile(widthPath(dest, 48))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = data
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("-widths without -resize-filter differs from -resize-filter bilinear")
+	}
+}
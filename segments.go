@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// JPEG文件中扫描数据之前的一个标记段
+type jpegSegment struct {
+	marker byte
+	// 标记段的内容，不含标记和长度字段
+	data []byte
+}
+
+// 解析JPEG文件中扫描数据(SOS)之前的所有标记段
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	var segments []jpegSegment
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return segments, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// 标记前的填充字节
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return segments, nil
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return segments, errors.New("truncated JPEG segment")
+		}
+		segments = append(segments, jpegSegment{marker, data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+	return segments, errors.New("missing JPEG scan data")
+}
+
+// 计算JPEG中元数据标记段（APP1-APP15和COM）占用的字节数
+func metadataSize(data []byte) int64 {
+	segments, _ := jpegSegments(data)
+	var size int64
+	for _, seg := range segments {
+		if (seg.marker >= 0xe1 && seg.marker <= 0xef) || seg.marker == 0xfe {
+			size += int64(len(seg.data)) + 4
+		}
+	}
+	return size
+}
+
+// 由 -copy-metadata-from 读取、写入每个输出的元数据标记段
+var copiedMetadata []jpegSegment
+
+// 返回JPEG中的EXIF/XMP(APP1)、IPTC(APP13)和注释(COM)标记段
+func metadataSegments(data []byte) ([]jpegSegment, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	var meta []jpegSegment
+	for _, seg := range segments {
+		if seg.marker == 0xe1 || seg.marker == 0xed || seg.marker == 0xfe {
+			meta = append(meta, seg)
+		}
+	}
+	return meta, nil
+}
+
+// 在SOI之后依次插入标记段
+func withSegments(data []byte, segments []jpegSegment) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	for _, seg := range segments {
+		out = append(out, 0xff, seg.marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+len(seg.data)))
+		out = append(out, seg.data...)
+	}
+	return append(out, data[2:]...)
+}
+
+// 设置后，写入的JPEG只保留解码需要的标记段
+var stripAll bool
+
+// 删除JPEG扫描数据之前的APPn和COM标记段，只保留JFIF APP0和影响颜色转换的Adobe APP14。
+// 非JPEG数据原样返回
+func stripMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return data, nil
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return append(out, data[i:]...), nil
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		if essentialSegment(marker, data[i+4:i+2+length]) {
+			out = append(out, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	return nil, errors.New("missing JPEG scan data")
+}
+
+// 判断标记段是否是解码所需的，而不是可以删除的元数据
+func essentialSegment(marker byte, data []byte) bool {
+	switch {
+	case marker == 0xe0:
+		return bytes.HasPrefix(data, []byte("JFIF\x00"))
+	case marker == 0xee:
+		return bytes.HasPrefix(data, []byte("Adobe"))
+	case marker >= 0xe1 && marker <= 0xef, marker == 0xfe:
+		return false
+	}
+	return true
+}
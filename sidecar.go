@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 与源图片同名的 .target 文件路径，URL没有对应的文件时返回空字符串
+func targetSidecarPath(src string) string {
+	if isURL(src) {
+		return ""
+	}
+	return src + ".target"
+}
+
+// 读取与源图片同名的 .target 文件中的目标SSIM，文件不存在时ok为false
+func readTargetSidecar(src string) (target float64, ok bool, err error) {
+	p := targetSidecarPath(src)
+	if p == "" {
+		return 0, false, nil
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	target, err = strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if target <= 0 || target > 1 {
+		return 0, false, errors.New("target has to be more than 0 and at most 1")
+	}
+	return target, true, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSidecarDescribesWrittenFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		outcome  string
+		metadata bool
+		hashed   bool
+	}{
+		{"payload-copied", []string{"-payload-size", "-t", "0.99999"}, "copied", true, false},
+		{"strip-copied", []string{"-strip-all", "-t", "0.99999"}, "copied", false, false},
+		{"strip-optimized", []string{"-strip-all", "-t", "0.99"}, "optimized", false, false},
+		{"hash-optimized", []string{"-hash-name", "sha256", "-t", "0.99"}, "optimized", false, true},
+		{"hash-copied", []string{"-hash-name", "sha256", "-t", "0.99999"}, "copied", true, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		src := writeTaggedJPEG(t, t.TempDir(), 100)
+		dest := filepath.Join(dir, "out.jpg")
+		args := append(append([]string{"-sidecar"}, tt.args...), src, dest)
+		if _, stderr, code := runMain(t, args...); code != 0 {
+			t.Fatalf("%s: exit %d: %s", tt.name, code, stderr)
+		}
+
+		raw, err := os.ReadFile(dest + ".json")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var r sidecarReport
+		if err := json.Unmarshal(raw, &r); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.Outcome != tt.outcome {
+			t.Fatalf("%s: outcome %q, want %q", tt.name, r.Outcome, tt.outcome)
+		}
+
+		if hashed := r.Output != dest && strings.HasPrefix(r.Output, dir); hashed != tt.hashed {
+			t.Errorf("%s: output %q, hashed = %v, want %v", tt.name, r.Output, hashed, tt.hashed)
+		}
+		written, err := os.ReadFile(r.Output)
+		if err != nil {
+			t.Fatalf("%s: sidecar output: %v", tt.name, err)
+		}
+		if r.FinalSize != int64(len(written)) {
+			t.Errorf("%s: final_size %d, file is %d bytes", tt.name, r.FinalSize, len(written))
+		}
+		if st, err := os.Stat(src); err != nil || r.OriginalSize != st.Size() {
+			t.Errorf("%s: original_size %d, source is %v bytes", tt.name, r.OriginalSize, st.Size())
+		}
+		meta, _ := metadataSegments(written)
+		if r.MetadataKept != tt.metadata || (len(meta) > 0) != tt.metadata {
+			t.Errorf("%s: metadata_kept %v, %d metadata segments written, want %v", tt.name, r.MetadataKept, len(meta), tt.metadata)
+		}
+	}
+}
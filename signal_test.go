@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 写入一张较大的彩色噪声PNG，使每次尝试都需要一些时间
+func writeNoisePNG(t *testing.T, dir string, w, h int) string {
+	t.Helper()
+	r := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "noise.png")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// 在子进程中运行，标准输出出现以prefix开头的行后发送SIGINT，返回退出码和全部输出
+func interruptAfter(t *testing.T, prefix string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	sc := bufio.NewScanner(stdout)
+	sent := false
+	for sc.Scan() {
+		out.WriteString(sc.Text() + "\n")
+		if !sent && strings.HasPrefix(sc.Text(), prefix) {
+			if err := cmd.Process.Signal(os.Interrupt); err != nil {
+				t.Fatal(err)
+			}
+			sent = true
+		}
+	}
+	err = cmd.Wait()
+	if !sent {
+		t.Fatalf("no line starting with %q:\n%s", prefix, out.String())
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		t.Fatalf("expected a nonzero exit, got %v:\n%s", err, out.String())
+	}
+	return exit.ExitCode(), out.String()
+}
+
+func TestInterruptDuringSearch(t *testing.T) {
+	dir := t.TempDir()
+	src := writeNoisePNG(t, dir, 800, 800)
+	dest := filepath.Join(dir, "out.jpg")
+	code, out := interruptAfter(t, "[1]", "-l", "50", "-min", "1", "-max", "100", "-t", "0.9999999", src, dest)
+	if code != interruptedExitCode {
+		t.Errorf("exit %d, want %d:\n%s", code, interruptedExitCode, out)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("%v was written although the run was interrupted", dest)
+	}
+}
+
+func TestInterruptDuringWidths(t *testing.T) {
+	dir := t.TempDir()
+	src := writeNoisePNG(t, dir, 800, 800)
+	dest := filepath.Join(dir, "out.jpg")
+	first, second := widthPath(dest, 700), widthPath(dest, 600)
+	code, out := interruptAfter(t, first, "-widths", "700,600", "-t", "0.9999999", src, dest)
+	if code != interruptedExitCode {
+		t.Errorf("exit %d, want %d:\n%s", code, interruptedExitCode, out)
+	}
+	if !isJpeg(first) {
+		t.Errorf("%v, written before the signal, is missing", first)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("%v was written although the run was interrupted", second)
+	}
+}
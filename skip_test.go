@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSkipDimensionsReadsOnlyHeader(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(writeTinyPNG(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 截断到IHDR之后：DecodeConfig能读出尺寸，完整解码则会失败
+	src := filepath.Join(dir, "header-only.png")
+	if err := os.WriteFile(src, data[:8+25], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readImage(src); err == nil {
+		t.Fatal("truncated PNG decoded without error")
+	}
+
+	dest := filepath.Join(dir, "out.jpg")
+	stdout, stderr, code := runMain(t, "-skip-dimensions", "64x64", src, dest)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "copied without recompressing") {
+		t.Errorf("stdout = %q, want the skip message", stdout)
+	}
+	out, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data[:8+25]) {
+		t.Error("output is not a copy of the source")
+	}
+
+	// 大于阈值时照常解码，截断的文件应报错
+	if _, _, code := runMain(t, "-skip-dimensions", "32x32", src, dest); code == 0 {
+		t.Error("image larger than -skip-dimensions was not decoded")
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	_ "image/gif"
 	"image/jpeg"
+	_ "image/png"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"jpeg-recompress/jpegenc"
 )
 
 // 默认SSIM常量
@@ -22,24 +29,139 @@ var (
 	C2 = math.Pow((K2 * L), 2.0)
 )
 
+// 读取图片时可区分的错误
+var (
+	errEmptyImage     = errors.New("image file is empty")
+	errNotImage       = errors.New("not a supported image")
+	errTruncatedImage = errors.New("image is truncated")
+)
+
+// 解码前允许的最大像素数，用于防止解压炸弹
+var maxPixels int64 = 200000000
+
+// 图片像素数超过 maxPixels 时的错误
+type tooLargeError struct {
+	width, height int
+	limit         int64
+}
+
+func (e tooLargeError) Error() string {
+	return fmt.Sprintf("image is %vx%v, which exceeds the limit of %v pixels", e.width, e.height, e.limit)
+}
+
+// 识别出了图片格式，但没有注册对应解码器时的错误
+type missingDecoderError struct {
+	contentType string
+}
+
+// 各图片格式对应的解码器包
+var decoderPackages = map[string]string{
+	"image/png":  "image/png",
+	"image/gif":  "image/gif",
+	"image/bmp":  "golang.org/x/image/bmp",
+	"image/webp": "golang.org/x/image/webp",
+}
+
+func (e missingDecoderError) Error() string {
+	msg := "detected " + e.contentType + " but this build has no decoder for it"
+	if pkg, ok := decoderPackages[e.contentType]; ok {
+		msg += "; rebuild with the decoder registered (import _ \"" + pkg + "\")"
+	}
+	return msg
+}
+
 // 读取图片
 func readImage(fname string) (image.Image, error) {
-	file, err := os.Open(fname)
-	if err != nil {
-		return nil, err
+	var r io.Reader
+	if isURL(fname) {
+		data, err := fetchURL(fname)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		file, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
 	}
-	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	img, err := decodeImage(r)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", fname, err)
 	}
-
 	return img, nil
 }
 
+// 只读取图片头部，返回尺寸和格式，不解码像素数据
+func readConfig(fname string) (image.Config, string, error) {
+	var r io.Reader
+	if isURL(fname) {
+		data, err := fetchURL(fname)
+		if err != nil {
+			return image.Config{}, "", err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		file, err := os.Open(fname)
+		if err != nil {
+			return image.Config{}, "", err
+		}
+		defer file.Close()
+		r = file
+	}
+	return image.DecodeConfig(r)
+}
+
+// 解码图片，将空文件、非图片和截断的图片转换为可区分的错误
+func decodeImage(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
+		return nil, errEmptyImage
+	}
+
+	head, _ := br.Peek(512)
+
+	// 隔行扫描的PNG解码时开销更大，在交给解码器之前就按IHDR中的尺寸检查
+	if w, h, _, ok := pngHeader(head); ok && int64(w)*int64(h) > maxPixels {
+		return nil, tooLargeError{w, h, maxPixels}
+	}
+
+	// 先只读取头部的尺寸，读过的数据保留下来供完整解码使用
+	var consumed bytes.Buffer
+	if cfg, _, err := image.DecodeConfig(io.TeeReader(br, &consumed)); err == nil {
+		if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+			return nil, tooLargeError{cfg.Width, cfg.Height, maxPixels}
+		}
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&consumed, br))
+	switch {
+	case err == nil:
+		return img, nil
+	case errors.Is(err, image.ErrFormat):
+		if contentType := http.DetectContentType(head); strings.HasPrefix(contentType, "image/") {
+			return nil, missingDecoderError{contentType}
+		}
+		return nil, errNotImage
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF), err == jpeg.FormatError("short Huffman data"):
+		return nil, errTruncatedImage
+	}
+	return nil, err
+}
+
 // 判断是否是JPEG格式图像
 func isJpeg(path string) bool {
+	if isURL(path) {
+		data, err := fetchURL(path)
+		if err != nil {
+			return false
+		}
+		return http.DetectContentType(data) == "image/jpeg"
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return false
@@ -57,6 +179,11 @@ func isJpeg(path string) bool {
 
 // 获得文件大小
 func getFilesize(path string) (size int64, err error) {
+	if isURL(path) {
+		data, err := fetchURL(path)
+		return int64(len(data)), err
+	}
+
 	fi, err := os.Stat(path)
 	if err != nil {
 		return
@@ -65,29 +192,56 @@ func getFilesize(path string) (size int64, err error) {
 	return
 }
 
+// 自定义编码参数，为nil时使用标准库编码器
+var customEncoder *jpegenc.Options
+
+// 返回自定义编码参数，尚未设置时创建
+func customOptions() *jpegenc.Options {
+	if customEncoder == nil {
+		customEncoder = &jpegenc.Options{}
+	}
+	return customEncoder
+}
+
 // 返回指定质量的图片的byte值
 func encodeToJPEGBytes(img image.Image, quality int) ([]byte, error) {
-	options := &jpeg.Options{
-		Quality: quality,
+	buf := getBuffer()
+	var err error
+	if customEncoder != nil {
+		options := *customEncoder
+		options.Quality = quality
+		err = jpegenc.Encode(buf, img, &options)
+	} else {
+		options := &jpeg.Options{
+			Quality: quality,
+		}
+		err = jpeg.Encode(buf, img, options)
 	}
-	buf := new(bytes.Buffer)
-	err := jpeg.Encode(buf, img, options)
+	data := releaseBuffer(buf)
 	if err != nil {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	if embedSRGB {
+		data = withICCProfile(data, srgbProfile())
+	}
+	// EXIF应是SOI之后的第一个标记段，所以最后插入
+	if len(copiedMetadata) > 0 {
+		data = withSegments(data, copiedMetadata)
+	}
+	return data, nil
 }
 
 // 转换为灰阶
 func convertToGray(originalImg image.Image) image.Image {
+	if p, ok := originalImg.(*image.Paletted); ok {
+		return palettedToGray(p)
+	}
 	bounds := originalImg.Bounds()
-	w, h := dim(originalImg)
-
 	grayImg := image.NewGray(bounds)
 
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 			originalColor := originalImg.At(x, y)
 			grayColor := color.GrayModel.Convert(originalColor)
 			grayImg.Set(x, y, grayColor)
@@ -105,7 +259,7 @@ func getPixVal(c color.Color) float64 {
 
 // 返回图像的宽和高
 func dim(img image.Image) (w, h int) {
-	w, h = img.Bounds().Max.X, img.Bounds().Max.Y
+	w, h = img.Bounds().Dx(), img.Bounds().Dy()
 	return
 }
 
@@ -116,53 +270,67 @@ func equalDim(img1, img2 image.Image) bool {
 	return (w1 == w2) && (h1 == h2)
 }
 
+// 检查两个图像的尺寸是否相同，不同时返回说明两者尺寸的错误
+func checkDim(img1, img2 image.Image) error {
+	if equalDim(img1, img2) {
+		return nil
+	}
+	w1, h1 := dim(img1)
+	w2, h2 := dim(img2)
+	return fmt.Errorf("images are %dx%d and %dx%d, they must have the same dimensions", w1, h1, w2, h2)
+}
+
 // 给定一个图像，计算其像素值的平均值
 func mean(img image.Image) float64 {
+	b := img.Bounds()
 	w, h := dim(img)
 	n := float64((w * h) - 1)
 	sum := 0.0
 
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
 			sum += getPixVal(img.At(x, y))
 		}
 	}
 	return sum / n
 }
 
-// 使用图像的像素值计算标准差
-func stdev(img image.Image) float64 {
+// 使用图像的像素值计算方差。不经过标准差再平方，
+// 使相同图像的方差与covar的结果完全相等
+func variance(img image.Image) float64 {
+	b := img.Bounds()
 	w, h := dim(img)
 
 	n := float64((w * h) - 1)
 	sum := 0.0
 	avg := mean(img)
 
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
-			pix := getPixVal(img.At(x, y))
-			sum += math.Pow((pix - avg), 2.0)
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			d := getPixVal(img.At(x, y)) - avg
+			sum += d * d
 		}
 	}
-	return math.Sqrt(sum / n)
+	return sum / n
 }
 
 // 计算图像的方差
 func covar(img1, img2 image.Image) (c float64, err error) {
-	if !equalDim(img1, img2) {
-		err = errors.New("images must have same dimension")
+	if err = checkDim(img1, img2); err != nil {
 		return
 	}
 	avg1 := mean(img1)
 	avg2 := mean(img2)
+	// 两个图像尺寸相同，但原点可以不同
+	min1, min2 := img1.Bounds().Min, img2.Bounds().Min
 	w, h := dim(img1)
 	sum := 0.0
 	n := float64((w * h) - 1)
 
 	for x := 0; x < w; x++ {
 		for y := 0; y < h; y++ {
-			pix1 := getPixVal(img1.At(x, y))
-			pix2 := getPixVal(img2.At(x, y))
+			pix1 := getPixVal(img1.At(min1.X+x, min1.Y+y))
+			pix2 := getPixVal(img2.At(min2.X+x, min2.Y+y))
 			sum += (pix1 - avg1) * (pix2 - avg2)
 		}
 	}
@@ -172,61 +340,255 @@ func covar(img1, img2 image.Image) (c float64, err error) {
 
 // 计算两个图像的结构相似性SSIM
 func ssim(x, y image.Image) float64 {
+	if windowSize > 0 {
+		return clampSSIM(windowStat(x, y, windowSize))
+	}
+	if tileRows > 0 {
+		return clampSSIM(ssimStrips(x, y, tileRows))
+	}
+	return clampSSIM(globalSSIM(x, y))
+}
+
+// 在整个图像上计算一次SSIM
+func globalSSIM(x, y image.Image) float64 {
 	avgX := mean(x)
 	avgY := mean(y)
 
-	stdevX := stdev(x)
-	stdevY := stdev(y)
+	varX := variance(x)
+	varY := variance(y)
 
+	// 调用者保证尺寸相同，尺寸不同时是程序错误，不能当作SSIM为0继续
 	cov, err := covar(x, y)
 	if err != nil {
-		return 0.0
+		panic(err)
 	}
 
 	numerator := ((2.0 * avgX * avgY) + C1) * ((2.0 * cov) + C2)
-	denominator := (math.Pow(avgX, 2.0) + math.Pow(avgY, 2.0) + C1) * (math.Pow(stdevX, 2.0) + math.Pow(stdevY, 2.0) + C2)
+	denominator := (math.Pow(avgX, 2.0) + math.Pow(avgY, 2.0) + C1) * (varX + varY + C2)
 
 	return numerator / denominator
 }
 
+// SSIM不超过1，但浮点误差可能使几乎相同的图像略大于1
+func clampSSIM(index float64) float64 {
+	if index > 1 {
+		return 1
+	}
+	return index
+}
+
+// 某一质量的比较结果
+type candidate struct {
+	index float64
+	size  int64
+	// 是否满足目标SSIM及其他接受条件
+	meets bool
+}
+
 // 返回压缩后托的SSIM和图片大小
 func compare(original image.Image, quality int) (index float64, raw []byte, err error) {
 	raw, err = encodeToJPEGBytes(original, quality)
 	if err != nil {
 		return
 	}
+	index, err = measure(original, raw)
+	return
+}
+
+// 解码压缩后的图片，返回其与参考图像的SSIM。
+// 参考图像为原图灰阶；按通道计算时为彩色原图
+func measure(reference image.Image, raw []byte) (index float64, err error) {
 	decoded, err := jpeg.Decode(bytes.NewReader(raw))
 	if err != nil {
 		return
 	}
-	index = ssim(original, convertToGray(decoded))
+	if err = checkDim(reference, decoded); err != nil {
+		return
+	}
+	if borderCrop > 0 {
+		reference = cropBorder(reference, borderCrop)
+		decoded = cropBorder(decoded, borderCrop)
+	}
+	if metric == "pixel-diff" {
+		index = pixelsWithin(reference, convertToGray(decoded), pixelThreshold)
+		return
+	}
+	if perChannel() {
+		weights := channelWeights
+		if weights == nil {
+			weights = &[3]float64{1, 0, 0}
+		}
+		index = weightedSSIM(reference, decoded, weights)
+		return
+	}
+	if lowMemory {
+		index = streamSSIM(reference, decoded)
+		return
+	}
+	index = ssim(reference, convertToGray(decoded))
 	return
 }
 
 // 写入文件
 func save(p string, data []byte) (err error) {
+	if stripAll {
+		if data, err = stripMetadata(data); err != nil {
+			return
+		}
+	}
+	if hashAlgo != "" {
+		hashed := hashedPath(p, data)
+		fmt.Printf("%v -> %v\n", p, hashed)
+		p = hashed
+	}
+	lastOutput.path, lastOutput.data = p, data
+	if outputArchive != nil {
+		return outputArchive.add(p, data)
+	}
+
+	if err = prepareDir(p); err != nil {
+		return
+	}
 	f, err := os.Create(p)
 	if err != nil {
-		log.Println(err)
+		return
 	}
-	defer f.Close()
-	f.Write(data)
-	return
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return applyAttributes(p)
+}
+
+// 设置后，写出的文件沿用该源文件的时间戳
+var timesFrom os.FileInfo
+
+// 不为0时，写出的文件使用该权限而不受umask影响
+var outputMode os.FileMode
+
+// 最近一次写出的输出路径，以及经save写出的数据，直接复制的文件为nil
+var lastOutput struct {
+	path string
+	data []byte
+}
+
+// 对写出的文件应用 outputMode 和 timesFrom。
+// timesFrom的修改时间同时用作访问时间和修改时间
+func applyAttributes(p string) error {
+	if outputMode != 0 {
+		if err := os.Chmod(p, outputMode); err != nil {
+			return err
+		}
+	}
+	if timesFrom == nil {
+		return nil
+	}
+	return os.Chtimes(p, timesFrom.ModTime(), timesFrom.ModTime())
+}
+
+// 是否自动创建输出文件所在的目录
+var makeDirs bool
+
+// 检查输出文件所在的目录是否存在，设置了makeDirs时自动创建
+func prepareDir(p string) error {
+	dir := filepath.Dir(p)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return nil
+	}
+	if makeDirs {
+		return os.MkdirAll(dir, 0755)
+	}
+	return errors.New("destination directory '" + dir + "' does not exist, use -mkdir to create it")
 }
 
 // 复制文件
 func copyFile(src string, dest string) (nBytes int64, err error) {
+	if outputArchive != nil || hashAlgo != "" || stripAll {
+		data, err := readSource(src)
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(data)), save(dest, data)
+	}
+
+	if isURL(src) {
+		data, err := fetchURL(src)
+		if err != nil {
+			return 0, err
+		}
+		if err = os.WriteFile(dest, data, 0666); err != nil {
+			return 0, err
+		}
+		lastOutput.path, lastOutput.data = dest, data
+		return int64(len(data)), applyAttributes(dest)
+	}
+
+	// 源文件和目标文件相同时，os.Create会在复制前清空源文件
+	if sameFile(src, dest) {
+		lastOutput.path, lastOutput.data = dest, nil
+		return getFilesize(src)
+	}
+
 	source, err := os.Open(src)
 	if err != nil {
 		return 0, err
 	}
 	defer source.Close()
 
+	if err := prepareDir(dest); err != nil {
+		return 0, err
+	}
 	destination, err := os.Create(dest)
 	if err != nil {
 		return 0, err
 	}
-	defer destination.Close()
 	nBytes, err = io.Copy(destination, source)
-	return nBytes, err
+	if cerr := destination.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nBytes, err
+	}
+	lastOutput.path, lastOutput.data = dest, nil
+	return nBytes, applyAttributes(dest)
+}
+
+// 判断两个路径是否指向同一个文件
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// 写入的输出比原图大时删除输出并改为复制原图，返回是否进行了替换。
+// 输出写入归档或按哈希命名时无法替换，不做检查
+func ensureNotLarger(src, dest string) (bool, error) {
+	if outputArchive != nil || hashAlgo != "" || isURL(src) {
+		return false, nil
+	}
+	srcSize, err := getFilesize(src)
+	if err != nil {
+		return false, err
+	}
+	destSize, err := getFilesize(dest)
+	if err != nil {
+		return false, err
+	}
+	if destSize <= srcSize {
+		return false, nil
+	}
+	if err := os.Remove(dest); err != nil {
+		return false, err
+	}
+	_, err = copyFile(src, dest)
+	return true, err
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// 一种SSIM的实现，比较灰阶参考图像与解码后的图像
+type ssimImpl struct {
+	name    string
+	measure func(reference, decoded image.Image) float64
+}
+
+// 所有可选的SSIM实现，第一个作为比较的基准
+var ssimImpls = []ssimImpl{
+	{"global", func(reference, decoded image.Image) float64 {
+		return globalSSIM(reference, convertToGray(decoded))
+	}},
+	{"strips", func(reference, decoded image.Image) float64 {
+		return ssimStrips(reference, convertToGray(decoded), streamRows)
+	}},
+	{"stream", func(reference, decoded image.Image) float64 {
+		return ssimStrips(reference, grayView{decoded}, streamRows)
+	}},
+	{"window 8", func(reference, decoded image.Image) float64 {
+		return mssim(reference, convertToGray(decoded), 8)
+	}},
+}
+
+// 以指定质量编码一次，用每种SSIM实现测量，打印得分、与基准的差和耗时的对比表。
+// window 是不同的指标，与基准的差不代表错误
+func compareSSIMImpls(w io.Writer, original, reference image.Image, quality int) error {
+	data, err := encodeToJPEGBytes(original, quality)
+	if err != nil {
+		return err
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Implementation\tSSIM\tDiff\tTime")
+	var base float64
+	for i, impl := range ssimImpls {
+		start := time.Now()
+		index := impl.measure(reference, decoded)
+		elapsed := time.Since(start)
+		if i == 0 {
+			base = index
+		}
+		fmt.Fprintf(tw, "%v\t%.10f\t%+.1e\t%v\n", impl.name, index, index-base, elapsed.Round(time.Millisecond))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import "image"
+
+// 选择下一个尝试质量的方式：binary 取区间中点，golden 取区间的黄金分割点
+var searchStrategy = "binary"
+
+// 黄金分割比例 (sqrt(5)-1)/2
+const goldenRatio = 0.6180339887498949
+
+// 返回区间 [minQ, maxQ] 中下一个要尝试的质量，minQ < maxQ 时结果总小于maxQ
+func nextQuality(minQ, maxQ int) int {
+	if searchStrategy == "golden" {
+		return minQ + int(float64(maxQ-minQ)*goldenRatio)
+	}
+	return minQ + (maxQ-minQ)/2
+}
+
+// 在[minQ, maxQ]中搜索满足目标SSIM的最小输出，返回其质量和大小
+func smallestMeeting(img, reference image.Image, target float64, minQ, maxQ, loops int) (bestQ int, bestSize int64, ok bool) {
+	for attempt := 1; attempt <= loops && minQ < maxQ; attempt++ {
+		q := nextQuality(minQ, maxQ)
+		data, err := encodeToJPEGBytes(img, q)
+		if err != nil {
+			return
+		}
+		index, err := measure(reference, data)
+		if err != nil {
+			return
+		}
+		if index >= target {
+			if !ok || int64(len(data)) < bestSize {
+				bestQ, bestSize, ok = q, int64(len(data)), true
+			}
+			maxQ = q
+		} else {
+			minQ = q + 1
+		}
+	}
+	return
+}
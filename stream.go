@@ -0,0 +1,38 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// 设置后，不为解码结果另外分配灰阶图像，按条带逐行累积SSIM统计量
+var lowMemory bool
+
+// lowMemory 模式下未指定 tileRows 时每条的行数
+const streamRows = 64
+
+// 读取时才把像素转换为灰阶的图像视图，转换结果与convertToGray相同
+type grayView struct {
+	image.Image
+}
+
+func (g grayView) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+func (g grayView) At(x, y int) color.Color {
+	return color.GrayModel.Convert(g.Image.At(x, y))
+}
+
+// 不复制解码结果，计算其与灰阶参考图像的SSIM
+func streamSSIM(reference, decoded image.Image) float64 {
+	gray := grayView{decoded}
+	if windowSize > 0 {
+		return clampSSIM(windowStat(reference, gray, windowSize))
+	}
+	rows := tileRows
+	if rows == 0 {
+		rows = streamRows
+	}
+	return clampSSIM(ssimStrips(reference, gray, rows))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 带GPS子IFD的EXIF：IFD0中的0x8825指向只有GPSLatitudeRef的GPS IFD
+func gpsExif() []byte {
+	tiff := make([]byte, 8+2+12+4+2+12+4)
+	be := binary.BigEndian
+	copy(tiff, "MM")
+	be.PutUint16(tiff[2:], 42)
+	be.PutUint32(tiff[4:], 8)
+	be.PutUint16(tiff[8:], 1)
+	be.PutUint16(tiff[10:], 0x8825)
+	be.PutUint16(tiff[12:], 4)
+	be.PutUint32(tiff[14:], 1)
+	be.PutUint32(tiff[18:], 26)
+	be.PutUint16(tiff[26:], 1)
+	be.PutUint16(tiff[28:], 0x0001)
+	be.PutUint16(tiff[30:], 2)
+	be.PutUint32(tiff[32:], 2)
+	copy(tiff[36:], "N\x00")
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// 写出一个带有各类元数据标记段的JPEG
+func writeTaggedJPEG(t *testing.T, dir string, quality int) string {
+	t.Helper()
+	raw, err := encodeToJPEGBytes(loadFixture(t, "photo"), quality)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := withSegments(raw, []jpegSegment{
+		{0xe1, gpsExif()},
+		{0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><exif:GPSLatitude>52,31N</exif:GPSLatitude></x:xmpmeta>")},
+		{0xe0, []byte("JFXX\x00\x10thumbnail")},
+		{0xe2, []byte("ICC_PROFILE\x00\x01\x01profile")},
+		{0xed, []byte("Photoshop 3.0\x008BIM\x04\x04iptc")},
+		{0xee, []byte("Adobe\x00\x64\x00\x00\x00\x00\x01")},
+		{0xfe, []byte("shot at home")},
+	})
+	p := filepath.Join(dir, "tagged.jpg")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// 检查JPEG中只剩下解码需要的标记段，且不含任何EXIF、XMP或GPS数据
+func checkStripped(t *testing.T, name string, data []byte) {
+	t.Helper()
+	segments, err := jpegSegments(data)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	for _, seg := range segments {
+		if !essentialSegment(seg.marker, seg.data) {
+			t.Errorf("%s: kept segment 0x%x %q", name, seg.marker, seg.data)
+		}
+	}
+	for _, leak := range []string{"Exif", "http://ns.adobe.com/xap", "GPS", "shot at home", "8BIM", "ICC_PROFILE"} {
+		if bytes.Contains(data, []byte(leak)) {
+			t.Errorf("%s: output still contains %q", name, leak)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("%s: stripped output does not decode: %v", name, err)
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	data, err := os.ReadFile(writeTaggedJPEG(t, t.TempDir(), 90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := stripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStripped(t, "stripMetadata", stripped)
+	if !bytes.Contains(stripped, []byte("Adobe")) {
+		t.Error("Adobe APP14 was removed although it affects color conversion")
+	}
+}
+
+func TestStripAllOutputs(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, stdout string
+		args         []string
+	}{
+		{"optimized", "Final image", []string{"-t", "0.99"}},
+		// 目标无法达到时复制原图，复制的文件同样要去除元数据
+		{"copied", "copying oringal image", []string{"-t", "0.99999"}},
+	}
+	for _, tt := range tests {
+		src := writeTaggedJPEG(t, t.TempDir(), 100)
+		dest := filepath.Join(dir, tt.name+".jpg")
+		stdout, stderr, code := runMain(t, append(append([]string{"-strip-all"}, tt.args...), src, dest)...)
+		if code != 0 {
+			t.Fatalf("%s: exit %d: %s", tt.name, code, stderr)
+		}
+		if !strings.Contains(stdout, tt.stdout) {
+			t.Fatalf("%s: stdout %q does not contain %q", tt.name, stdout, tt.stdout)
+		}
+		data, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkStripped(t, tt.name, data)
+	}
+}
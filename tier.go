@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// -tier 中的一条规则：宽度满足条件时使用的目标SSIM
+type tierRule struct {
+	op     string
+	width  int
+	target float64
+}
+
+// 解析 w<=480:t=0.995,w<=1920:t=0.9999 形式的规则，运算符可以是 < <= > >=
+func parseTiers(s string) ([]tierRule, error) {
+	var rules []tierRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ReplaceAll(part, " ", "")
+		cond, t, ok := strings.Cut(part, ":t=")
+		if !ok || !strings.HasPrefix(cond, "w") {
+			return nil, errors.New("'" + part + "' is not in w<=WIDTH:t=TARGET form")
+		}
+		cond = cond[1:]
+		var r tierRule
+		for _, op := range []string{"<=", ">=", "<", ">"} {
+			if strings.HasPrefix(cond, op) {
+				r.op = op
+				break
+			}
+		}
+		if r.op == "" {
+			return nil, errors.New("'" + part + "' needs one of < <= > >=")
+		}
+		var err error
+		if r.width, err = strconv.Atoi(cond[len(r.op):]); err != nil {
+			return nil, errors.New("'" + part + "' has an invalid width")
+		}
+		if r.target, err = strconv.ParseFloat(t, 64); err != nil || r.target <= 0 || r.target > 1 {
+			return nil, errors.New("'" + part + "' has a target outside of 0 to 1")
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// 返回第一条匹配宽度的规则的目标
+func tierTarget(rules []tierRule, width int) (float64, bool) {
+	for _, r := range rules {
+		var match bool
+		switch r.op {
+		case "<":
+			match = width < r.width
+		case "<=":
+			match = width <= r.width
+		case ">":
+			match = width > r.width
+		case ">=":
+			match = width >= r.width
+		}
+		if match {
+			return r.target, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// 分条计算SSIM时每条的行数，为0时不分条
+var tileRows int
+
+// 一组像素的统计量：均值、离差平方和以及协离差和
+type moments struct {
+	n            float64
+	meanX, meanY float64
+	m2X, m2Y     float64
+	coXY         float64
+}
+
+// 计算两个图像中第y0到y1行的统计量，行号相对于各自图像的原点
+func stripMoments(x, y image.Image, y0, y1 int) (m moments) {
+	minX, minY := x.Bounds().Min, y.Bounds().Min
+	w, _ := dim(x)
+	m.n = float64(w * (y1 - y0))
+	if m.n == 0 {
+		return
+	}
+
+	for row := y0; row < y1; row++ {
+		for col := 0; col < w; col++ {
+			m.meanX += getPixVal(x.At(minX.X+col, minX.Y+row))
+			m.meanY += getPixVal(y.At(minY.X+col, minY.Y+row))
+		}
+	}
+	m.meanX /= m.n
+	m.meanY /= m.n
+
+	for row := y0; row < y1; row++ {
+		for col := 0; col < w; col++ {
+			dx := getPixVal(x.At(minX.X+col, minX.Y+row)) - m.meanX
+			dy := getPixVal(y.At(minY.X+col, minY.Y+row)) - m.meanY
+			m.m2X += dx * dx
+			m.m2Y += dy * dy
+			m.coXY += dx * dy
+		}
+	}
+	return
+}
+
+// 合并两组统计量（Chan等人的并行算法），避免直接累加平方和带来的精度损失
+func (a moments) merge(b moments) moments {
+	if a.n == 0 {
+		return b
+	}
+	if b.n == 0 {
+		return a
+	}
+	n := a.n + b.n
+	dx := b.meanX - a.meanX
+	dy := b.meanY - a.meanY
+	return moments{
+		n:     n,
+		meanX: a.meanX + dx*b.n/n,
+		meanY: a.meanY + dy*b.n/n,
+		m2X:   a.m2X + b.m2X + dx*dx*a.n*b.n/n,
+		m2Y:   a.m2Y + b.m2Y + dy*dy*a.n*b.n/n,
+		coXY:  a.coXY + b.coXY + dx*dy*a.n*b.n/n,
+	}
+}
+
+// 按水平条带累积统计量计算SSIM，结果与ssim的整图计算一致
+func ssimStrips(x, y image.Image, rows int) float64 {
+	if !equalDim(x, y) {
+		return 0.0
+	}
+	_, h := dim(x)
+
+	var total moments
+	for y0 := 0; y0 < h; y0 += rows {
+		y1 := y0 + rows
+		if y1 > h {
+			y1 = h
+		}
+		total = total.merge(stripMoments(x, y, y0, y1))
+	}
+
+	// 与mean、stdev和covar一样以 n-1 作为分母
+	n := total.n
+	avgX := total.meanX * n / (n - 1)
+	avgY := total.meanY * n / (n - 1)
+	varX := (total.m2X + n*math.Pow(total.meanX-avgX, 2.0)) / (n - 1)
+	varY := (total.m2Y + n*math.Pow(total.meanY-avgY, 2.0)) / (n - 1)
+	cov := (total.coXY + n*(total.meanX-avgX)*(total.meanY-avgY)) / (n - 1)
+
+	numerator := ((2.0 * avgX * avgY) + C1) * ((2.0 * cov) + C2)
+	denominator := (math.Pow(avgX, 2.0) + math.Pow(avgY, 2.0) + C1) * (varX + varY + C2)
+
+	return numerator / denominator
+}
@@ -0,0 +1,7 @@
+package main
+
+// -keep-better 的代价函数：size + weight * (1 - SSIM) * originalSize。
+// 原图的SSIM为1，代价就是它的大小；weight越大，越倾向于保留原图
+func tradeoffCost(size int64, index float64, originalSize int64, weight float64) float64 {
+	return float64(size) + weight*(1-index)*float64(originalSize)
+}
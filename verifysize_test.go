@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 写出一个带有n字节注释段(COM)的小JPEG，作为 -copy-metadata-from 的来源
+func writeCommentedJPEG(t *testing.T, dir string, n int) string {
+	t.Helper()
+	raw, err := encodeToJPEGBytes(image.NewGray(image.Rect(0, 0, 8, 8)), 75)
+	if err != nil {
+		t.Fatal(err)
+	}
+	com := []byte{0xff, 0xfe, byte((n + 2) >> 8), byte(n + 2)}
+	com = append(com, bytes.Repeat([]byte("c"), n)...)
+	data := append(append(append([]byte{}, raw[:2]...), com...), raw[2:]...)
+	p := filepath.Join(dir, "commented.jpg")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestVerifySizeAfterMetadataInjection(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join("testdata", "photo.png")
+	original, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := writeCommentedJPEG(t, dir, 16000)
+	// 目标无法达到，退回最接近的质量；写入的注释使输出比原图大
+	args := []string{"-t", "0.99999", "-copy-metadata-from", meta}
+
+	enlarged := filepath.Join(dir, "enlarged.jpg")
+	if _, stderr, code := runMain(t, append(args, src, enlarged)...); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(enlarged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) <= len(original) {
+		t.Fatalf("output is %d bytes, not larger than the %d byte source; the test needs an enlarging case", len(data), len(original))
+	}
+
+	verified := filepath.Join(dir, "verified.jpg")
+	_, stderr, code := runMain(t, append(append([]string{"-verify-size"}, args...), src, verified)...)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	data, err = os.ReadFile(verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Errorf("-verify-size wrote %d bytes, want a copy of the %d byte source", len(data), len(original))
+	}
+	if !strings.Contains(stderr, "copied the original image instead") {
+		t.Errorf("stderr = %q, want the replacement warning", stderr)
+	}
+}
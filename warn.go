@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// 设置后，打印过警告的运行以退出码1结束。计为警告的情况有：
+// 曝光过度/不足、源图片已有ICC配置文件而未嵌入sRGB、交互模式没有终端、
+// 时间预算耗尽、回退到最接近的质量、输出比原图大而改为复制原图、无法计算感知哈希或彩色SSIM、
+// -widths 跳过的宽度或未达到目标的宽度、颜色很少的调色板图像
+var strict bool
+
+// 设置后不打印警告，但仍然计数
+var quiet bool
+
+// 出现过的警告数
+var warnings int
+
+// 计数一条警告，没有 -q 时打印到标准错误
+func warn(msg string) {
+	warnings++
+	if !quiet {
+		fmt.Fprintln(os.Stderr, "* "+msg)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarningsGoToStderr(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTinyPNG(t, dir)
+	// 非JPEG源达不到目标时回退到最接近的质量，这是一条警告
+	args := []string{"-t", "0.99999", src}
+
+	stdout, stderr, code := runMain(t, append(args, filepath.Join(dir, "loud.jpg"))...)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "* ") || strings.Contains(stdout, "closest match") {
+		t.Errorf("warning not on stderr only\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+
+	_, stderr, code = runMain(t, append([]string{"-q"}, append(args, filepath.Join(dir, "quiet.jpg"))...)...)
+	if code != 0 || stderr != "" {
+		t.Errorf("-q: exit %d, stderr %q", code, stderr)
+	}
+
+	_, stderr, code = runMain(t, append([]string{"-q", "-strict"}, append(args, filepath.Join(dir, "strict.jpg"))...)...)
+	if code != 1 || stderr != "" {
+		t.Errorf("-q -strict: exit %d, stderr %q, want 1 and no output", code, stderr)
+	}
+}
+
+func TestExposureWarningFormat(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	src := writeSource(t, "black.png", buf.Bytes())
+	_, stderr, code := runMain(t, src, filepath.Join(dir, "out.jpg"))
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "* 100% of pixels are near black") || strings.Contains(stderr, "Warning") {
+		t.Errorf("unexpected exposure warning:\n%s", stderr)
+	}
+}
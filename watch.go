@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 监视模式下处理的图片扩展名
+var watchExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// 文件在一次扫描中的状态
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// 判断是否是编辑器或下载工具的临时文件
+func isTempFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tmp", ".swp", ".part", ".crdownload":
+		return true
+	}
+	return false
+}
+
+// 影响单个图片处理方式、需要传给子进程的参数。
+// 归档、缓存、性能分析和其他运行模式的参数只对监视进程本身有意义，不传递
+var watchFlags = map[string]bool{
+	"max": true, "min": true, "t": true, "max-drop": true, "l": true, "q": true, "v": true, "c": true,
+	"strict": true, "explain": true, "sidecar": true, "bpp": true, "ssim-display": true,
+	"skip-dimensions": true, "strategy": true, "low-memory": true, "target-bpp": true,
+	"keep-better": true, "quality-weight": true, "cost": true, "adaptive-target": true, "adaptive-spread": true,
+	"strip-all": true, "copy-metadata-from": true, "embed-srgb": true, "widths": true, "resize-filter": true,
+	"filter": true, "tier": true, "pin": true, "no-fallback-enlarge": true, "abort-on-larger": true,
+	"color-ssim": true, "entropy": true, "phash": true, "check-determinism": true,
+	"auto-grayscale": true, "dither": true, "chmod": true, "preserve-times": true, "max-pixels": true,
+	"time-budget": true, "hash-name": true, "hash-length": true, "verify-size": true,
+	"separate-chroma": true, "chroma-target": true, "min-psnr": true, "payload-size": true,
+	"minimize-size": true, "seed": true, "crop-border": true, "window": true, "acceptance": true,
+	"tile-rows": true, "channel-weights": true, "metric": true, "pixel-threshold": true, "ssim-space": true,
+	"optimize": true, "restart-interval": true, "qtables": true,
+}
+
+// 返回显式设置的单图参数，用于处理单个文件的子进程
+func passthroughFlags() []string {
+	args := []string{"-f", "-mkdir"}
+	flag.Visit(func(f *flag.Flag) {
+		if watchFlags[f.Name] {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// 返回监视目录中rel对应的输出路径，PNG和GIF的输出是JPEG，扩展名改为.jpg
+func watchOutputPath(outDir, rel string) (string, error) {
+	out, err := confinedPath(outDir, rel)
+	if err != nil {
+		return "", err
+	}
+	switch ext := filepath.Ext(out); strings.ToLower(ext) {
+	case ".png", ".gif":
+		out = strings.TrimSuffix(out, ext) + ".jpg"
+	}
+	return out, nil
+}
+
+// 轮询监视目录，对新出现或修改过的图片在两次扫描间保持不变后进行压缩，
+// 输出到outDir下相同的相对路径，直到收到中断信号
+func watchDir(dir, outDir string, interval time.Duration) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := passthroughFlags()
+	absOut, _ := filepath.Abs(outDir)
+
+	scan := func() (map[string]fileState, error) {
+		states := map[string]fileState{}
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// 输出目录在监视目录内时不处理自己的输出
+			if abs, _ := filepath.Abs(p); d.IsDir() && abs == absOut {
+				return filepath.SkipDir
+			}
+			if d.IsDir() || isTempFile(d.Name()) || !watchExtensions[strings.ToLower(filepath.Ext(p))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			states[p] = fileState{info.Size(), info.ModTime()}
+			return nil
+		})
+		return states, err
+	}
+
+	// 已有的文件视为已处理
+	done, err := scan()
+	if err != nil {
+		return err
+	}
+	pending := map[string]fileState{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Printf("Watching %v, writing to %v\n", dir, outDir)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+		}
+
+		states, err := scan()
+		if err != nil {
+			return err
+		}
+		for p, st := range states {
+			if done[p] == st {
+				continue
+			}
+			// 与上次扫描相同才处理，避免读到写了一半的文件
+			if pending[p] != st {
+				pending[p] = st
+				continue
+			}
+			delete(pending, p)
+			done[p] = st
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			out, err := watchOutputPath(outDir, rel)
+			if err != nil {
+				fmt.Printf("%v: skipped: %v\n", p, err)
+				continue
+			}
+			var output bytes.Buffer
+			cmd := exec.Command(self, append(args, p, out)...)
+			cmd.Stdout, cmd.Stderr = &output, &output
+			if err := cmd.Run(); err != nil {
+				lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+				fmt.Printf("%v: failed: %v\n", p, lines[len(lines)-1])
+				continue
+			}
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			fmt.Printf("%v -> %v: %v\n", p, out, lines[len(lines)-1])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPassthroughFlags(t *testing.T) {
+	defer func(saved *flag.FlagSet) { flag.CommandLine = saved }(flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("jpeg-recompress", flag.ContinueOnError)
+	for _, name := range []string{"watch", "watch-interval", "t", "strip-all", "archive", "cache", "cpuprofile", "compare-dir", "f", "mkdir"} {
+		flag.String(name, "", "")
+	}
+	set := [][2]string{
+		{"watch", "true"}, {"watch-interval", "2s"}, {"t", "0.999"}, {"strip-all", "true"},
+		{"archive", "out.zip"}, {"cache", "cache"}, {"cpuprofile", "cpu.out"}, {"f", "true"},
+	}
+	for _, kv := range set {
+		if err := flag.Set(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"-f", "-mkdir", "-strip-all=true", "-t=0.999"}
+	if got := passthroughFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("passthroughFlags() = %q, want %q", got, want)
+	}
+}
+
+func TestWatchOutputPath(t *testing.T) {
+	tests := []struct {
+		rel, want string
+	}{
+		{"a.jpg", "out/a.jpg"},
+		{"b.JPEG", "out/b.JPEG"},
+		{"sub/c.png", "out/sub/c.jpg"},
+		{"d.GIF", "out/d.jpg"},
+		{"e.png.png", "out/e.png.jpg"},
+	}
+	for _, tt := range tests {
+		got, err := watchOutputPath("out", tt.rel)
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("watchOutputPath(%q) = %q, %v, want %q", tt.rel, got, err, tt.want)
+		}
+	}
+	if _, err := watchOutputPath("out", "../a.png"); err == nil {
+		t.Error("watchOutputPath accepted a path outside the output directory")
+	}
+}
+
+func TestWatchWritesJPEGForPNG(t *testing.T) {
+	in, out := t.TempDir(), filepath.Join(t.TempDir(), "out")
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	cmd := exec.Command(os.Args[0], "-watch", "-watch-interval", "20ms", "-cache", cacheDir, in, out)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(os.Interrupt)
+
+	// 等待监视进程完成第一次扫描后再放入新文件
+	time.Sleep(200 * time.Millisecond)
+	png, err := os.ReadFile(filepath.Join("testdata", "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(in, "photo.png"), png, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(out, "photo.jpg")
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(want); err == nil {
+			break
+		}
+	}
+	if !isJpeg(want) {
+		t.Fatalf("%v is missing or not a JPEG", want)
+	}
+	if _, err := os.Stat(filepath.Join(out, "photo.png")); err == nil {
+		t.Error("output was written with the .png extension")
+	}
+	// -cache 只属于监视进程，不应传给处理单个文件的子进程
+	if _, err := os.Stat(cacheDir); err == nil {
+		t.Error("-cache was passed on to the per-image process")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// 窗口SSIM的窗口边长，为0时计算整图SSIM
+var windowSize int
+
+// 与目标比较的窗口SSIM统计量：mean、min 或 p5
+var windowAcceptance = "mean"
+
+// 单个窗口的SSIM及其实际包含的像素数
+type windowScore struct {
+	index float64
+	n     int
+}
+
+// 计算两个图像在size x size窗口上的SSIM。
+// 图像边缘不足一个窗口的部分裁剪到图像范围内，按实际像素数计算
+func windowSSIMs(x, y image.Image, size int) []windowScore {
+	if !equalDim(x, y) {
+		return nil
+	}
+	w, h := dim(x)
+
+	var scores []windowScore
+	for y0 := 0; y0 < h; y0 += size {
+		for x0 := 0; x0 < w; x0 += size {
+			r := image.Rect(x0, y0, x0+size, y0+size).Intersect(image.Rect(0, 0, w, h))
+			scores = append(scores, windowScore{windowIndex(x, y, r), r.Dx() * r.Dy()})
+		}
+	}
+	return scores
+}
+
+// 计算两个图像在矩形区域内的SSIM，r相对于各自图像的原点
+func windowIndex(x, y image.Image, r image.Rectangle) float64 {
+	minX, minY := x.Bounds().Min, y.Bounds().Min
+	n := float64(r.Dx() * r.Dy())
+	sumX, sumY := 0.0, 0.0
+	for row := r.Min.Y; row < r.Max.Y; row++ {
+		for col := r.Min.X; col < r.Max.X; col++ {
+			sumX += getPixVal(x.At(minX.X+col, minX.Y+row))
+			sumY += getPixVal(y.At(minY.X+col, minY.Y+row))
+		}
+	}
+	avgX, avgY := sumX/n, sumY/n
+
+	varX, varY, cov := 0.0, 0.0, 0.0
+	for row := r.Min.Y; row < r.Max.Y; row++ {
+		for col := r.Min.X; col < r.Max.X; col++ {
+			dx := getPixVal(x.At(minX.X+col, minX.Y+row)) - avgX
+			dy := getPixVal(y.At(minY.X+col, minY.Y+row)) - avgY
+			varX += dx * dx
+			varY += dy * dy
+			cov += dx * dy
+		}
+	}
+	varX, varY, cov = varX/n, varY/n, cov/n
+
+	numerator := ((2.0 * avgX * avgY) + C1) * ((2.0 * cov) + C2)
+	denominator := (math.Pow(avgX, 2.0) + math.Pow(avgY, 2.0) + C1) * (varX + varY + C2)
+
+	return numerator / denominator
+}
+
+// 计算窗口SSIM的平均值(MSSIM)，按各窗口的像素数加权，
+// 使边缘的不完整窗口不会被高估
+func mssim(x, y image.Image, size int) float64 {
+	scores := windowSSIMs(x, y, size)
+	sum, n := 0.0, 0
+	for _, s := range scores {
+		sum += s.index * float64(s.n)
+		n += s.n
+	}
+	if n == 0 {
+		return 0.0
+	}
+	return sum / float64(n)
+}
+
+// 按窗口像素数加权，返回处于第p百分位的窗口SSIM，p为0时即最差的窗口
+func windowPercentile(scores []windowScore, p float64) float64 {
+	if len(scores) == 0 {
+		return 0.0
+	}
+	sorted := append([]windowScore(nil), scores...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].index < sorted[j].index })
+
+	total := 0
+	for _, s := range sorted {
+		total += s.n
+	}
+	limit := p / 100 * float64(total)
+	seen := 0
+	for _, s := range sorted {
+		seen += s.n
+		if float64(seen) >= limit {
+			return s.index
+		}
+	}
+	return sorted[len(sorted)-1].index
+}
+
+// 按 windowAcceptance 计算窗口SSIM的统计量
+func windowStat(x, y image.Image, size int) float64 {
+	switch windowAcceptance {
+	case "min":
+		return windowPercentile(windowSSIMs(x, y, size), 0)
+	case "p5":
+		return windowPercentile(windowSSIMs(x, y, size), 5)
+	}
+	return mssim(x, y, size)
+}
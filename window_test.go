@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// 生成带纹理的灰阶图像，以及在其上叠加固定扰动的副本
+func texturedPair(w, h int) (*image.Gray, *image.Gray) {
+	x := image.NewGray(image.Rect(0, 0, w, h))
+	y := image.NewGray(x.Bounds())
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			v := uint8(60 + (col*11+row*17)%130)
+			x.SetGray(col, row, color.Gray{Y: v})
+			y.SetGray(col, row, color.Gray{Y: v + uint8((col+2*row)%7)})
+		}
+	}
+	return x, y
+}
+
+func TestWindowSSIMsEdgeWindows(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+		windows    int
+	}{
+		{16, 16, 8, 4},
+		{19, 13, 8, 6},
+		{2000, 3, 8, 250},
+		{3, 2000, 8, 250},
+		{1, 1, 8, 1},
+		{10000, 10, 8, 2500},
+	}
+	for _, tt := range tests {
+		x, y := texturedPair(tt.w, tt.h)
+		scores := windowSSIMs(x, y, tt.size)
+		if len(scores) != tt.windows {
+			t.Errorf("%dx%d: %d windows, want %d", tt.w, tt.h, len(scores), tt.windows)
+		}
+		// 每个像素恰好计入一个窗口
+		pixels := 0
+		for _, s := range scores {
+			if s.n <= 0 || s.n > tt.size*tt.size {
+				t.Errorf("%dx%d: window with %d pixels", tt.w, tt.h, s.n)
+			}
+			pixels += s.n
+		}
+		if pixels != tt.w*tt.h {
+			t.Errorf("%dx%d: windows cover %d pixels, want %d", tt.w, tt.h, pixels, tt.w*tt.h)
+		}
+		if got := mssim(x, x, tt.size); math.Abs(got-1) > 1e-12 {
+			t.Errorf("%dx%d: MSSIM of identical images = %v, want 1", tt.w, tt.h, got)
+		}
+	}
+}
+
+func TestMSSIMWeightsPartialWindows(t *testing.T) {
+	// 20x12的图像分为3x2个窗口，右下角的窗口只有4x4个像素
+	x, y := texturedPair(20, 12)
+	want, n := 0.0, 0
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 8, 8), image.Rect(8, 0, 16, 8), image.Rect(16, 0, 20, 8),
+		image.Rect(0, 8, 8, 12), image.Rect(8, 8, 16, 12), image.Rect(16, 8, 20, 12),
+	} {
+		want += windowIndex(x, y, r) * float64(r.Dx()*r.Dy())
+		n += r.Dx() * r.Dy()
+	}
+	want /= float64(n)
+	if got := mssim(x, y, 8); math.Abs(got-want) > 1e-12 {
+		t.Errorf("MSSIM = %v, want the pixel-weighted mean %v", got, want)
+	}
+}